@@ -0,0 +1,338 @@
+package stremio
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+// AccessLogOptions configure an access log, which is independent of the application logger and the request logging via Options.Logger.
+type AccessLogOptions struct {
+	// Format of the access log lines.
+	// "common" for the Common Log Format, "combined" for the Combined Log Format (which adds referer and user agent), or "json".
+	// An empty value disables the access log.
+	// Default "".
+	Format string `config:"format"`
+	// Writer to write the access log to.
+	// Can't be combined with File.
+	// Default os.Stdout (if File isn't set either).
+	Output io.Writer
+	// Path of a file to write the access log to.
+	// The file is rotated when it reaches MaxSize, keeping MaxBackups old files with the suffixes ".1", ".2" etc.
+	// Default "".
+	File string `config:"file"`
+	// Size in bytes after which the file is rotated.
+	// Default 100 MB.
+	MaxSize int64 `config:"max_size"`
+	// Number of rotated files to keep.
+	// Default 3.
+	MaxBackups int `config:"max_backups"`
+	// Fields to include in JSON access logs, in this order.
+	// Valid fields are "time", "requestID", "ip", "method", "url", "protocol", "status", "bytesSent", "referer", "userAgent",
+	// "cacheStatus" (like "HIT" for ETag matches or "STALE" for the TimeoutFallbackCache fallback),
+	// "handlerDuration" (time spent in your handler) and "duration" (total time of the request).
+	// The Common and Combined Log Formats have a fixed set of fields.
+	// Default: all fields.
+	Fields []string `config:"fields"`
+	// Fraction of requests (0 to 1) that are logged, by URL path.
+	// Useful for high-volume endpoints, for example `map[string]float64{"/health": 0.01}` logs only 1% of health check requests.
+	// Paths that aren't in the map are always logged.
+	// Default nil.
+	SampleRates map[string]float64 `config:"sample_rates"`
+}
+
+// accessLogFields are the valid fields for JSON access logs, in their default order.
+var accessLogFields = []string{"time", "requestID", "ip", "method", "url", "protocol", "status", "bytesSent", "referer", "userAgent", "cacheStatus", "handlerDuration", "duration"}
+
+// DefaultAccessLogOptions is an AccessLogOptions object with default values.
+// For fields that aren't set here the zero value is the default value.
+var DefaultAccessLogOptions = AccessLogOptions{
+	MaxSize:    100 * 1024 * 1024,
+	MaxBackups: 3,
+}
+
+func (o AccessLogOptions) validate() error {
+	switch o.Format {
+	case "":
+		if o.Output != nil || o.File != "" || o.Fields != nil || o.SampleRates != nil {
+			return errors.New("Configuring the access log only makes sense when also setting its format")
+		}
+		return nil
+	case "common", "combined", "json":
+	default:
+		return fmt.Errorf("Unknown access log format %q", o.Format)
+	}
+	if o.Output != nil && o.File != "" {
+		return errors.New("Setting both an access log output and file doesn't make sense")
+	} else if o.File == "" && (o.MaxSize != 0 || o.MaxBackups != 0) {
+		return errors.New("Setting access log rotation options only makes sense when also setting an access log file")
+	} else if o.MaxSize < 0 || o.MaxBackups < 0 {
+		return errors.New("Access log rotation options must not be negative")
+	} else if o.Format != "json" && o.Fields != nil {
+		return errors.New("Selecting access log fields only makes sense for the JSON format")
+	}
+	for _, field := range o.Fields {
+		if !containsString(accessLogFields, field) {
+			return fmt.Errorf("Unknown access log field %q", field)
+		}
+	}
+	for path, rate := range o.SampleRates {
+		if rate < 0 || rate > 1 {
+			return fmt.Errorf("Access log sample rate for %q must be between 0 and 1", path)
+		}
+	}
+	return nil
+}
+
+func containsString(s []string, v string) bool {
+	for _, e := range s {
+		if e == v {
+			return true
+		}
+	}
+	return false
+}
+
+// rotatingFile is an io.Writer that writes to a file and rotates it when it reaches a maximum size.
+type rotatingFile struct {
+	path       string
+	maxSize    int64
+	maxBackups int
+
+	file *os.File
+	size int64
+	lock *sync.Mutex
+}
+
+func newRotatingFile(path string, maxSize int64, maxBackups int) (*rotatingFile, error) {
+	rf := &rotatingFile{
+		path:       path,
+		maxSize:    maxSize,
+		maxBackups: maxBackups,
+		lock:       &sync.Mutex{},
+	}
+	if err := rf.open(); err != nil {
+		return nil, err
+	}
+	return rf, nil
+}
+
+func (rf *rotatingFile) open() error {
+	file, err := os.OpenFile(rf.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("Couldn't open access log file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Couldn't stat access log file: %w", err)
+	}
+	rf.file = file
+	rf.size = info.Size()
+	return nil
+}
+
+func (rf *rotatingFile) rotate() error {
+	if err := rf.file.Close(); err != nil {
+		return err
+	}
+	// Shift old files: path.2 -> path.3, path.1 -> path.2, path -> path.1. The oldest one is overwritten.
+	for i := rf.maxBackups - 1; i > 0; i-- {
+		_ = os.Rename(rf.path+"."+strconv.Itoa(i), rf.path+"."+strconv.Itoa(i+1))
+	}
+	if err := os.Rename(rf.path, rf.path+".1"); err != nil {
+		return err
+	}
+	return rf.open()
+}
+
+// Write writes p to the file, rotating the file first if p wouldn't fit anymore.
+func (rf *rotatingFile) Write(p []byte) (int, error) {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	if rf.size > 0 && rf.size+int64(len(p)) > rf.maxSize {
+		if err := rf.rotate(); err != nil {
+			return 0, fmt.Errorf("Couldn't rotate access log file: %w", err)
+		}
+	}
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+// Close closes the current file.
+func (rf *rotatingFile) Close() error {
+	rf.lock.Lock()
+	defer rf.lock.Unlock()
+	return rf.file.Close()
+}
+
+// newAccessLogWriter returns the writer for the access log according to the options.
+func newAccessLogWriter(opts AccessLogOptions) (io.Writer, error) {
+	if opts.Output != nil {
+		return opts.Output, nil
+	} else if opts.File == "" {
+		return os.Stdout, nil
+	}
+	maxSize, maxBackups := opts.MaxSize, opts.MaxBackups
+	if maxSize == 0 {
+		maxSize = DefaultAccessLogOptions.MaxSize
+	}
+	if maxBackups == 0 {
+		maxBackups = DefaultAccessLogOptions.MaxBackups
+	}
+	return newRotatingFile(opts.File, maxSize, maxBackups)
+}
+
+// accessLogEntry contains the data of one request.
+type accessLogEntry struct {
+	time            time.Time
+	requestID       string
+	ip              string
+	method          string
+	url             string
+	protocol        string
+	status          int
+	bytesSent       int
+	referer         string
+	userAgent       string
+	cacheStatus     string
+	handlerDuration time.Duration
+	duration        time.Duration
+}
+
+// clfTimeFormat is the time format of the Common Log Format.
+const clfTimeFormat = "02/Jan/2006:15:04:05 -0700"
+
+// clfValue returns "-" for empty values, as required by the Common Log Format.
+func clfValue(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
+}
+
+func (e accessLogEntry) appendCommon(buf *bytes.Buffer) {
+	fmt.Fprintf(buf, "%s - - [%s] \"%s %s %s\" %d %d", clfValue(e.ip), e.time.Format(clfTimeFormat), e.method, e.url, e.protocol, e.status, e.bytesSent)
+}
+
+func (e accessLogEntry) appendCombined(buf *bytes.Buffer) {
+	e.appendCommon(buf)
+	fmt.Fprintf(buf, " %q %q", clfValue(e.referer), clfValue(e.userAgent))
+}
+
+func (e accessLogEntry) appendJSON(buf *bytes.Buffer, fields []string) {
+	buf.WriteByte('{')
+	for i, field := range fields {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		var v interface{}
+		switch field {
+		case "time":
+			v = e.time.Format(time.RFC3339Nano)
+		case "requestID":
+			v = e.requestID
+		case "ip":
+			v = e.ip
+		case "method":
+			v = e.method
+		case "url":
+			v = e.url
+		case "protocol":
+			v = e.protocol
+		case "status":
+			v = e.status
+		case "bytesSent":
+			v = e.bytesSent
+		case "referer":
+			v = e.referer
+		case "userAgent":
+			v = e.userAgent
+		case "cacheStatus":
+			v = e.cacheStatus
+		case "handlerDuration":
+			v = e.handlerDuration.Seconds()
+		case "duration":
+			v = e.duration.Seconds()
+		}
+		// Marshalling strings and numbers can't fail
+		key, _ := json.Marshal(field)
+		val, _ := json.Marshal(v)
+		buf.Write(key)
+		buf.WriteByte(':')
+		buf.Write(val)
+	}
+	buf.WriteByte('}')
+}
+
+func createAccessLogMiddleware(opts AccessLogOptions, w io.Writer, trustedProxies []*net.IPNet, redact bool, logger logging.Logger) fiber.Handler {
+	fields := opts.Fields
+	if fields == nil {
+		fields = accessLogFields
+	}
+	bufPool := sync.Pool{
+		New: func() interface{} {
+			return &bytes.Buffer{}
+		},
+	}
+
+	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		err := c.Next()
+
+		if rate, ok := opts.SampleRates[c.Path()]; ok && rand.Float64() >= rate {
+			return err
+		}
+
+		entry := accessLogEntry{
+			time:      start,
+			ip:        clientIP(c, trustedProxies),
+			method:    c.Method(),
+			url:       c.OriginalURL(),
+			protocol:  string(c.Request().Header.Protocol()),
+			status:    c.Response().StatusCode(),
+			bytesSent: len(c.Response().Body()),
+			referer:   c.Get(fiber.HeaderReferer),
+			userAgent: c.Get(fiber.HeaderUserAgent),
+			duration:  time.Since(start),
+		}
+		entry.requestID, _ = c.Locals("requestID").(string)
+		entry.cacheStatus, _ = c.Locals("cacheStatus").(string)
+		entry.handlerDuration, _ = c.Locals("handlerDuration").(time.Duration)
+		if redact {
+			entry.url = redactURL(entry.url)
+			entry.ip = anonymizeIP(entry.ip)
+		}
+
+		buf := bufPool.Get().(*bytes.Buffer)
+		buf.Reset()
+		defer bufPool.Put(buf)
+		switch opts.Format {
+		case "common":
+			entry.appendCommon(buf)
+		case "combined":
+			entry.appendCombined(buf)
+		case "json":
+			entry.appendJSON(buf, fields)
+		}
+		buf.WriteByte('\n')
+		if _, writeErr := w.Write(buf.Bytes()); writeErr != nil {
+			logger.Error("Couldn't write access log", "error", writeErr)
+		}
+
+		return err
+	}
+}
@@ -0,0 +1,106 @@
+package stremio
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestAccessLogFormats(t *testing.T) {
+	entry := accessLogEntry{
+		time:            time.Date(2021, 2, 3, 4, 5, 6, 0, time.FixedZone("", 3600)),
+		requestID:       "abc",
+		ip:              "1.2.3.0",
+		method:          "GET",
+		url:             "/stream/movie/tt1254207.json",
+		protocol:        "HTTP/1.1",
+		status:          200,
+		bytesSent:       42,
+		userAgent:       "Stremio/4.4",
+		cacheStatus:     "MISS",
+		handlerDuration: 250 * time.Millisecond,
+		duration:        500 * time.Millisecond,
+	}
+	tests := []struct {
+		format   string
+		fields   []string
+		expected string
+	}{
+		{"common", nil, `1.2.3.0 - - [03/Feb/2021:04:05:06 +0100] "GET /stream/movie/tt1254207.json HTTP/1.1" 200 42`},
+		{"combined", nil, `1.2.3.0 - - [03/Feb/2021:04:05:06 +0100] "GET /stream/movie/tt1254207.json HTTP/1.1" 200 42 "-" "Stremio/4.4"`},
+		{"json", accessLogFields, `{"time":"2021-02-03T04:05:06+01:00","requestID":"abc","ip":"1.2.3.0","method":"GET","url":"/stream/movie/tt1254207.json","protocol":"HTTP/1.1","status":200,"bytesSent":42,"referer":"","userAgent":"Stremio/4.4","cacheStatus":"MISS","handlerDuration":0.25,"duration":0.5}`},
+		{"json", []string{"status", "url"}, `{"status":200,"url":"/stream/movie/tt1254207.json"}`},
+	}
+	for _, tc := range tests {
+		buf := &bytes.Buffer{}
+		switch tc.format {
+		case "common":
+			entry.appendCommon(buf)
+		case "combined":
+			entry.appendCombined(buf)
+		case "json":
+			entry.appendJSON(buf, tc.fields)
+		}
+		require.Equal(t, tc.expected, buf.String(), tc.format)
+	}
+}
+
+func TestAccessLogMiddleware(t *testing.T) {
+	out := &bytes.Buffer{}
+	opts := AccessLogOptions{
+		Format:      "json",
+		Fields:      []string{"method", "url", "status", "bytesSent"},
+		SampleRates: map[string]float64{"/health": 0},
+	}
+	app := fiber.New()
+	app.Use(createAccessLogMiddleware(opts, out, nil, true, logging.NewNopLogger()))
+	app.Get("/health", func(c *fiber.Ctx) error { return c.SendString("OK") })
+	app.Get("/:userData/manifest.json", func(c *fiber.Ctx) error { return c.SendString("{}") })
+
+	for _, path := range []string{"/health", "/secret/manifest.json"} {
+		_, err := app.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+	}
+	// The health check isn't sampled and the user data is redacted
+	expected := `{"method":"GET","url":"/` + userDataFingerprint("secret") + `/manifest.json","status":200,"bytesSent":2}` + "\n"
+	require.Equal(t, expected, out.String())
+}
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "access.log")
+	rf, err := newRotatingFile(path, 10, 2)
+	require.NoError(t, err)
+	for _, line := range []string{"first\n", "second\n", "third\n", "fourth\n"} {
+		_, err := rf.Write([]byte(line))
+		require.NoError(t, err)
+	}
+	require.NoError(t, rf.Close())
+
+	// Each line exceeds the max size together with the previous one, and only two backups are kept
+	for suffix, expected := range map[string]string{"": "fourth\n", ".1": "third\n", ".2": "second\n"} {
+		content, err := os.ReadFile(path + suffix)
+		require.NoError(t, err)
+		require.Equal(t, expected, string(content), suffix)
+	}
+	_, err = os.Stat(path + ".3")
+	require.True(t, os.IsNotExist(err))
+
+	// Appends to the existing file after a restart
+	rf, err = newRotatingFile(path, 100, 2)
+	require.NoError(t, err)
+	_, err = rf.Write([]byte("fifth\n"))
+	require.NoError(t, err)
+	require.NoError(t, rf.Close())
+	content, err := os.ReadFile(path)
+	require.NoError(t, err)
+	require.Equal(t, []string{"fourth", "fifth", ""}, strings.Split(string(content), "\n"))
+}
@@ -4,6 +4,8 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"net"
 	"net/http"
 	netpprof "net/http/pprof"
 	"os"
@@ -11,6 +13,8 @@ import (
 	"reflect"
 	"runtime/pprof"
 	"strconv"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -19,9 +23,9 @@ import (
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
 	"github.com/gofiber/fiber/v2/middleware/recover"
-	"go.uber.org/zap"
 
 	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+	"github.com/deflix-tv/go-stremio/pkg/logging"
 )
 
 // ManifestCallback is the callback for manifest requests, so mostly addon installations.
@@ -45,7 +49,10 @@ type ManifestCallback func(ctx context.Context, manifest *Manifest, userData int
 type CatalogHandler func(ctx context.Context, id string, userData interface{}) ([]MetaPreviewItem, error)
 
 // StreamHandler is the callback for stream requests for a specific type (like "movie").
-// The context parameter contains a meta object under the key "meta" if PutMetaInContext was set to true in the addon options.
+// The context parameter contains a meta object under the key "meta" if PutMetaInContext was set to true in the addon options,
+// and for TV show episodes the episode's video under the key "video" (see cinemeta.GetMetaFromContext() and cinemeta.GetVideoFromContext()).
+// The parsed ID is in the context as well (see videoid.GetIDFromContext()).
+// Like for all handlers, the context is cancelled when the client disconnects, the server shuts down or the handler timeout (if configured) is reached.
 // The id parameter can be for example an IMDb ID if your addon handles the "movie" type.
 // The userData parameter depends on whether you called `RegisterUserData()` before:
 // If not, a simple string will be passed. It's empty if the user didn't provide user data.
@@ -65,98 +72,175 @@ type MetaFetcher interface {
 // Addon represents a remote addon.
 // You can create one with NewAddon() and then run it with Run().
 type Addon struct {
-	manifest          Manifest
-	catalogHandlers   map[string]CatalogHandler
-	streamHandlers    map[string]StreamHandler
-	metaHandlers      map[string]MetaHandler
+	// The manifest and handlers, which can be replaced at runtime
+	state             *atomic.Pointer[addonState]
+	stateLock         *sync.Mutex
 	opts              Options
-	logger            *zap.Logger
+	logger            logging.Logger
 	customMiddlewares []customMiddleware
 	customEndpoints   []customEndpoint
 	manifestCallback  ManifestCallback
+	reloadCallback    ReloadCallback
 	userDataType      reflect.Type
 	metaClient        MetaFetcher
+	trustedProxies    []*net.IPNet
+	accessLogWriter   io.Writer
+	healthChecks      []*healthCheck
+	// Only set when the addon created the Cinemeta client, for stopping the cache's background cleanup or closing its file
+	closeCinemetaCache func() error
+}
+
+// episodeFetcher is implemented by MetaFetchers that can also get the episode of a TV show, like cinemeta.Client.
+// The meta middleware then puts the episode's video into the context as well.
+type episodeFetcher interface {
+	GetEpisode(ctx context.Context, imdbID string, season int, episode int) (cinemeta.Meta, cinemeta.Video, error)
+}
+
+// resourceRoutes returns the paths of the routes for the resource ("catalog", "stream" or "meta"),
+// with user data and, if it's not required, without. For catalogs it includes the routes with extra.
+func resourceRoutes(resource string, requiresUserData bool) []string {
+	paths := []string{"/" + resource + "/:type/:id.json"}
+	if resource == "catalog" {
+		paths = append(paths, "/catalog/:type/:id/:extra.json")
+	}
+	var routes []string
+	for _, path := range paths {
+		if !requiresUserData {
+			routes = append(routes, path)
+		}
+		routes = append(routes, "/:userData"+path)
+	}
+	return routes
+}
+
+// pinger is implemented by MetaFetchers that can be used for the Cinemeta health check, like cinemeta.Client.
+type pinger interface {
+	Ping(ctx context.Context) error
 }
 
 // NewAddon creates a new Addon object that can be started with Run().
 // A proper manifest must be supplied, but manifestCallback and all but one handler can be nil in case you only want to handle specific requests and opts can be the zero value of Options.
 func NewAddon(manifest Manifest, catalogHandlers map[string]CatalogHandler, streamHandlers map[string]StreamHandler, metaHandlers map[string]MetaHandler, opts Options) (*Addon, error) {
+	return newAddon(manifest, handlerSet{
+		catalog: catalogHandlers,
+		stream:  streamHandlers,
+		meta:    metaHandlers,
+	}, opts)
+}
+
+func newAddon(manifest Manifest, handlers handlerSet, opts Options) (*Addon, error) {
 	// Precondition checks
 	if manifest.ID == "" || manifest.Name == "" || manifest.Description == "" || manifest.Version == "" {
 		return nil, errors.New("An empty manifest was passed")
-	} else if catalogHandlers == nil && streamHandlers == nil && metaHandlers == nil {
+	} else if handlers.empty() {
 		return nil, errors.New("No handler was passed")
-	} else if (opts.CachePublicCatalogs && opts.CacheAgeCatalogs == 0) ||
-		(opts.CachePublicMeta && opts.CacheAgeMeta == 0) ||
-		(opts.CachePublicStreams && opts.CacheAgeStreams == 0) {
-		return nil, errors.New("Enabling public caching only makes sense when also setting a cache age")
-	} else if (opts.HandleEtagCatalogs && opts.CacheAgeCatalogs == 0) ||
-		(opts.HandleEtagStreams && opts.CacheAgeStreams == 0) {
-		return nil, errors.New("ETag handling only makes sense when also setting a cache age")
-	} else if opts.DisableRequestLogging && (opts.LogIPs || opts.LogUserAgent) {
-		return nil, errors.New("Enabling IP or user agent logging doesn't make sense when disabling request logging")
-	} else if opts.Logger != nil && opts.LoggingLevel != "" {
-		return nil, errors.New("Setting a logging level in the options doesn't make sense when you already set a custom logger")
-	} else if opts.DisableRequestLogging && opts.LogMediaName {
-		return nil, errors.New("Enabling media name logging doesn't make sense when disabling request logging")
-	} else if opts.MetaClient != nil && !opts.LogMediaName && !opts.PutMetaInContext {
-		return nil, errors.New("Setting a meta client when neither logging the media name nor putting it in the context doesn't make sense")
-	} else if opts.MetaClient != nil && opts.CinemetaTimeout != 0 {
-		return nil, errors.New("Setting a Cinemeta timeout doesn't make sense when you already set a meta client")
 	} else if manifest.BehaviorHints.ConfigurationRequired && !manifest.BehaviorHints.Configurable {
 		return nil, errors.New("Requiring a configuration only makes sense when also making the addon configurable")
 	} else if opts.ConfigureHTMLfs != nil && !manifest.BehaviorHints.Configurable {
 		return nil, errors.New("Setting a ConfigureHTMLfs only makes sense when also making the addon configurable")
 		// Note: The other way around is fine: We allow an addon creator to make the addon configurable, but then add his own "/configure" endpoint.
 	}
-
-	// Set default values
-	if opts.BindAddr == "" {
-		opts.BindAddr = DefaultOptions.BindAddr
-	}
-	if opts.Port == 0 {
-		opts.Port = DefaultOptions.Port
+	if err := opts.validate(); err != nil {
+		return nil, err
 	}
-	if opts.LoggingLevel == "" {
-		opts.LoggingLevel = DefaultOptions.LoggingLevel
-	}
-	if opts.LogEncoding == "" {
-		opts.LogEncoding = DefaultOptions.LogEncoding
+	if opts.StrictManifestValidation {
+		if err := validateManifest(manifest, handlers); err != nil {
+			return nil, fmt.Errorf("Invalid manifest: %w", err)
+		}
 	}
-	if opts.CinemetaTimeout == 0 {
-		opts.CinemetaTimeout = DefaultOptions.CinemetaTimeout
+	// Already validated by opts.validate(), but we need the parsed result
+	trustedProxies, err := parseTrustedProxies(opts.RateLimits.TrustedProxies)
+	if err != nil {
+		return nil, err
 	}
 
+	// Set default values
+	opts.setDefaults()
+
 	// Configure logger if no custom one is set
 	if opts.Logger == nil {
-		var err error
-		if opts.Logger, err = NewLogger(opts.LoggingLevel, opts.LogEncoding); err != nil {
+		zapLogger, err := NewLogger(opts.LoggingLevel, opts.LogEncoding)
+		if err != nil {
 			return nil, fmt.Errorf("Couldn't create new logger: %w", err)
 		}
+		opts.Logger = logging.NewZapLogger(zapLogger)
+	}
+	var accessLogWriter io.Writer
+	if opts.AccessLog.Format != "" {
+		if accessLogWriter, err = newAccessLogWriter(opts.AccessLog); err != nil {
+			return nil, err
+		}
 	}
 	// Configure Cinemeta client if no custom MetaFetcher is set
+	var closeCinemetaCache func() error
 	if opts.MetaClient == nil && (opts.LogMediaName || opts.PutMetaInContext) {
-		cinemetaCache := cinemeta.NewInMemoryCache()
+		var cinemetaCache cinemeta.Cache
+		if opts.CinemetaCacheFile != "" {
+			fileCache, err := cinemeta.NewFileCache(opts.CinemetaCacheFile, cinemeta.FileCacheOptions{
+				MaxEntries: opts.CinemetaCacheSize,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("Couldn't create Cinemeta cache: %w", err)
+			}
+			opts.Logger.Info("Loaded Cinemeta cache", "file", opts.CinemetaCacheFile, "entries", fileCache.Len())
+			cinemetaCache, closeCinemetaCache = fileCache, fileCache.Close
+		} else {
+			lruCache, err := cinemeta.NewLRUCache(cinemeta.LRUCacheOptions{
+				MaxEntries: opts.CinemetaCacheSize,
+			})
+			if err != nil {
+				return nil, fmt.Errorf("Couldn't create Cinemeta cache: %w", err)
+			}
+			cinemetaCache = lruCache
+			closeCinemetaCache = func() error {
+				lruCache.Close()
+				return nil
+			}
+		}
 		cinemetaOpts := cinemeta.ClientOptions{
-			Timeout: opts.CinemetaTimeout,
+			Timeout:        opts.CinemetaTimeout,
+			Metrics:        opts.Metrics,
+			TracerProvider: opts.TracerProvider,
+			// Cinemeta being down shouldn't make the addon slow or fail for media that was looked up before
+			ServeStaleOnError:       true,
+			CircuitBreakerThreshold: 5,
 		}
 		opts.MetaClient = cinemeta.NewClient(cinemetaOpts, cinemetaCache, opts.Logger)
 	}
 
+	var healthChecks []*healthCheck
+	if opts.HealthCheckCinemeta {
+		p, ok := opts.MetaClient.(pinger)
+		if !ok {
+			return nil, errors.New("Checking Cinemeta's health requires a meta client with a Ping method")
+		}
+		healthChecks = append(healthChecks, newHealthCheck("cinemeta", p.Ping, HealthCheckOptions{CacheDuration: max(opts.HealthCheckCinemetaCacheDuration, 0)}))
+	}
+
+	state, err := newAddonState(manifest, handlers)
+	if err != nil {
+		return nil, err
+	}
+	statePtr := &atomic.Pointer[addonState]{}
+	statePtr.Store(state)
+
 	// Create and return addon
 	return &Addon{
-		manifest:        manifest,
-		catalogHandlers: catalogHandlers,
-		streamHandlers:  streamHandlers,
-		metaHandlers:    metaHandlers,
-		opts:            opts,
-		logger:          opts.Logger,
-		metaClient:      opts.MetaClient,
+		state:              statePtr,
+		stateLock:          &sync.Mutex{},
+		opts:               opts,
+		logger:             opts.Logger,
+		metaClient:         opts.MetaClient,
+		trustedProxies:     trustedProxies,
+		accessLogWriter:    accessLogWriter,
+		healthChecks:       healthChecks,
+		closeCinemetaCache: closeCinemetaCache,
 	}, nil
 }
 
 // RegisterUserData registers the type of userData, so the addon can automatically unmarshal user data into an object of this type
 // and pass the object into the manifest callback or catalog and stream handlers.
+// Fields with secret values like API tokens should be tagged with `stremio:"secret"`, so that their values aren't logged.
 func (a *Addon) RegisterUserData(userDataObject interface{}) {
 	t := reflect.TypeOf(userDataObject)
 	if t.Kind() == reflect.Ptr {
@@ -200,6 +284,20 @@ func (a *Addon) AddEndpoint(method, path string, handler fiber.Handler) {
 	a.customEndpoints = append(a.customEndpoints, customEndpoint)
 }
 
+// AddHealthCheck adds a named check to the readiness endpoint ("/health/ready").
+// The endpoint responds with "503 Service Unavailable" when one of the checks fails.
+// A check with the same name as an existing one replaces it.
+func (a *Addon) AddHealthCheck(name string, check HealthCheck, opts HealthCheckOptions) {
+	hc := newHealthCheck(name, check, opts)
+	for i, existing := range a.healthChecks {
+		if existing.name == name {
+			a.healthChecks[i] = hc
+			return
+		}
+	}
+	a.healthChecks = append(a.healthChecks, hc)
+}
+
 // SetManifestCallback sets the manifest callback
 func (a *Addon) SetManifestCallback(callback ManifestCallback) {
 	a.manifestCallback = callback
@@ -210,11 +308,18 @@ func (a *Addon) SetManifestCallback(callback ManifestCallback) {
 // because of a system signal like Ctrl+C or `docker stop`. It should be a buffered channel with a capacity of 1.
 func (a *Addon) Run(stoppingChan chan bool) {
 	logger := a.logger
-	defer logger.Sync()
+	if syncer, ok := logger.(logging.Syncer); ok {
+		defer syncer.Sync()
+	}
+	// The behavior hints can't change at runtime, but the types can (see SetManifest()), so they're only used where that's acceptable
+	manifest := a.state.Load().manifest
+	getState := func() *addonState {
+		return a.state.Load()
+	}
 
 	// Make sure the passed channel is buffered, so we can send a message before shutting down and not be blocked by the channel.
 	if stoppingChan != nil && cap(stoppingChan) < 1 {
-		logger.Fatal("The passed stopping channel isn't buffered")
+		fatal(logger, "The passed stopping channel isn't buffered")
 	}
 
 	// Fiber app
@@ -232,18 +337,67 @@ func (a *Addon) Run(stoppingChan chan bool) {
 	// Middlewares
 
 	app.Use(recover.New())
+	// Tracing comes early so that the server span covers the other middlewares
+	if a.opts.TracerProvider != nil {
+		app.Use(createTracingMiddleware(a.opts.TracerProvider, a.opts.TracePropagator, !a.opts.DisableLogRedaction))
+	}
+	// Request IDs are required by the logging middleware, so this must come first
+	app.Use(createRequestIDMiddleware(logger))
+	if a.accessLogWriter != nil {
+		app.Use(createAccessLogMiddleware(a.opts.AccessLog, a.accessLogWriter, a.trustedProxies, !a.opts.DisableLogRedaction, logger))
+	}
 	if !a.opts.DisableRequestLogging {
-		app.Use(createLoggingMiddleware(logger, a.opts.LogIPs, a.opts.LogUserAgent, a.opts.LogMediaName, a.manifest.BehaviorHints.ConfigurationRequired))
+		app.Use(createLoggingMiddleware(logger, a.opts.LogIPs, a.opts.LogUserAgent, a.opts.LogMediaName, manifest.BehaviorHints.ConfigurationRequired, !a.opts.DisableLogRedaction))
 	}
 	if a.opts.Metrics {
-		app.Use(createMetricsMiddleware())
+		app.Use(createMetricsMiddleware(manifest.Types))
 	}
 	app.Use(corsMiddleware()) // Stremio doesn't show stream responses when no CORS middleware is used!
+	rateLimits := a.opts.RateLimits
+	if rateLimits.PerIP.enabled() {
+		app.Use(createIPRateLimitMiddleware(rateLimits.Store, rateLimits.PerIP, a.trustedProxies, logger))
+	}
 	// Filter some requests (like for requests without user data when the addon requires configuration, or for missing type or id URL parameters) and put some request info in the context
-	addRouteMatcherMiddleware(app, a.manifest.BehaviorHints.ConfigurationRequired, a.opts.StreamIDregex, logger)
-	metaMw := createMetaMiddleware(a.metaClient, a.opts.PutMetaInContext, a.opts.LogMediaName, logger)
+	addRouteMatcherMiddleware(app, manifest.BehaviorHints.ConfigurationRequired, a.opts.StreamIDregex, logger)
+	// Reject requests that don't match the manifest before they count towards any limit or lead to a Cinemeta request
+	if a.opts.StrictRequestValidation {
+		for _, resource := range []string{"catalog", "stream", "meta"} {
+			validationMw := createRequestValidationMiddleware(resource, getState, logger)
+			for _, route := range resourceRoutes(resource, manifest.BehaviorHints.ConfigurationRequired) {
+				app.Use(route, validationMw)
+			}
+		}
+	}
+	// Rate limits for specific resources and users
+	for _, resource := range []string{"catalog", "stream", "meta"} {
+		resourceLimit := rateLimits.PerResource[resource]
+		if !resourceLimit.enabled() && !rateLimits.PerUser.enabled() {
+			continue
+		}
+		rateLimitMw := createResourceRateLimitMiddleware(resource, rateLimits.Store, resourceLimit, rateLimits.PerUser, rateLimits.UserIdentity, a.trustedProxies, logger, a.userDataType, a.opts.UserDataIsBase64)
+		for _, route := range resourceRoutes(resource, manifest.BehaviorHints.ConfigurationRequired) {
+			app.Use(route, rateLimitMw)
+		}
+	}
+	// Concurrency limits. Registered before the meta middleware so that Cinemeta requests are covered as well.
+	concurrencyLimits := map[string]ConcurrencyLimit{
+		"catalog": a.opts.ConcurrencyLimitCatalogs,
+		"stream":  a.opts.ConcurrencyLimitStreams,
+		"meta":    a.opts.ConcurrencyLimitMeta,
+	}
+	for _, resource := range []string{"catalog", "stream", "meta"} {
+		limit := concurrencyLimits[resource]
+		if !limit.enabled() {
+			continue
+		}
+		concurrencyLimitMw := createConcurrencyLimitMiddleware(resource, limit, a.opts.Metrics, logger)
+		for _, route := range resourceRoutes(resource, manifest.BehaviorHints.ConfigurationRequired) {
+			app.Use(route, concurrencyLimitMw)
+		}
+	}
+	metaMw := createMetaMiddleware(a.metaClient, a.opts.PutMetaInContext, a.opts.LogMediaName, a.opts.Metrics, logger)
 	// Meta middleware only works for stream requests.
-	if !a.manifest.BehaviorHints.ConfigurationRequired {
+	if !manifest.BehaviorHints.ConfigurationRequired {
 		app.Use("/stream/:type/:id.json", metaMw)
 	}
 	app.Use("/:userData/stream/:type/:id.json", metaMw)
@@ -255,6 +409,9 @@ func (a *Addon) Run(stoppingChan chan bool) {
 	// Extra endpoints
 
 	app.Get("/health", createHealthHandler(logger))
+	health := newHealthChecker(a.healthChecks)
+	app.Get("/health/live", createLivenessHandler(logger))
+	app.Get("/health/ready", createReadinessHandler(health, logger))
 	// Optional profiling
 	if a.opts.Profiling {
 		group := app.Group("/debug/pprof")
@@ -279,35 +436,39 @@ func (a *Addon) Run(stoppingChan chan bool) {
 
 	// Stremio endpoints
 
+	// Handler contexts are derived from this one, so that they're cancelled when the server shuts down
+	baseCtx, cancelBaseCtx := context.WithCancel(context.Background())
+	defer cancelBaseCtx()
+
 	// In Fiber optional parameters don't work at the beginning of the URL, so we have to register two routes each
-	manifestHandler := createManifestHandler(a.manifest, logger, a.manifestCallback, a.userDataType, a.opts.UserDataIsBase64)
+	manifestHandler := createManifestHandler(getState, logger, a.manifestCallback, a.userDataType, a.opts.UserDataIsBase64)
 	// We always register this route, because even if BehaviorHints.ConfigurationRequired is true, this endpoint is required for the addon to be listed in Stremio's community addons.
 	app.Get("/manifest.json", manifestHandler)
 	app.Get("/:userData/manifest.json", manifestHandler)
-	if a.catalogHandlers != nil {
-		catalogHandler := createCatalogHandler(a.catalogHandlers, a.opts.CacheAgeCatalogs, a.opts.CachePublicCatalogs, a.opts.HandleEtagCatalogs, logger, a.userDataType, a.opts.UserDataIsBase64)
-		if !a.manifest.BehaviorHints.ConfigurationRequired {
-			app.Get("/catalog/:type/:id.json", catalogHandler)
-		}
-		// We always register this route, because we don't know if the addon developer wants to use user data or not, as BehaviorHints.Configurable only indicates the configurability *via Stremio*
-		app.Get("/:userData/catalog/:type/:id.json", catalogHandler)
+	// The resource routes are always registered, even without handlers, so that handlers can be added at runtime (see SetCatalogHandler() etc.)
+	catalogHandler := createCatalogHandler(func(t, id string) (handler, bool) { return getState().findCatalogHandler(t, id) }, a.opts.CacheAgeCatalogs, a.opts.CachePublicCatalogs, a.opts.HandleEtagCatalogs, a.opts.HandlerTimeoutCatalogs, a.opts.HandlerTimeoutFallback, baseCtx, logger, a.userDataType, a.opts.UserDataIsBase64)
+	if !manifest.BehaviorHints.ConfigurationRequired {
+		app.Get("/catalog/:type/:id.json", catalogHandler)
 	}
-	if a.streamHandlers != nil {
-		streamHandler := createStreamHandler(a.streamHandlers, a.opts.CacheAgeStreams, a.opts.CachePublicStreams, a.opts.HandleEtagStreams, logger, a.userDataType, a.opts.UserDataIsBase64)
-		if !a.manifest.BehaviorHints.ConfigurationRequired {
-			app.Get("/stream/:type/:id.json", streamHandler)
-		}
-		// We always register this route, because we don't know if the addon developer wants to use user data or not, as BehaviorHints.Configurable only indicates the configurability *via Stremio*
-		app.Get("/:userData/stream/:type/:id.json", streamHandler)
+	// We always register this route, because we don't know if the addon developer wants to use user data or not, as BehaviorHints.Configurable only indicates the configurability *via Stremio*
+	app.Get("/:userData/catalog/:type/:id.json", catalogHandler)
+	// Catalogs with extra, like "/catalog/movie/top/genre=Action.json"
+	if !manifest.BehaviorHints.ConfigurationRequired {
+		app.Get("/catalog/:type/:id/:extra.json", catalogHandler)
 	}
-	if a.metaHandlers != nil {
-		metaHandler := createMetaHandler(a.metaHandlers, a.opts.CacheAgeMeta, a.opts.CachePublicMeta, a.opts.HandleEtagMeta, logger, a.userDataType, a.opts.UserDataIsBase64)
-		if !a.manifest.BehaviorHints.ConfigurationRequired {
-			app.Get("/meta/:type/:id.json", metaHandler)
-		}
-		// We always register this route, because we don't know if the addon developer wants to use user data or not, as BehaviorHints.Configurable only indicates the configurability *via Stremio*
-		app.Get("/:userData/meta/:type/:id.json", metaHandler)
+	app.Get("/:userData/catalog/:type/:id/:extra.json", catalogHandler)
+	streamHandler := createStreamHandler(func(t, id string) (handler, bool) { return getState().findStreamHandler(t, id) }, a.opts.CacheAgeStreams, a.opts.CachePublicStreams, a.opts.HandleEtagStreams, a.opts.HandlerTimeoutStreams, a.opts.HandlerTimeoutFallback, baseCtx, logger, a.userDataType, a.opts.UserDataIsBase64)
+	if !manifest.BehaviorHints.ConfigurationRequired {
+		app.Get("/stream/:type/:id.json", streamHandler)
 	}
+	// We always register this route, because we don't know if the addon developer wants to use user data or not, as BehaviorHints.Configurable only indicates the configurability *via Stremio*
+	app.Get("/:userData/stream/:type/:id.json", streamHandler)
+	metaHandler := createMetaHandler(func(t, id string) (handler, bool) { return getState().findMetaHandler(t, id) }, a.opts.CacheAgeMeta, a.opts.CachePublicMeta, a.opts.HandleEtagMeta, a.opts.HandlerTimeoutMeta, a.opts.HandlerTimeoutFallback, baseCtx, logger, a.userDataType, a.opts.UserDataIsBase64)
+	if !manifest.BehaviorHints.ConfigurationRequired {
+		app.Get("/meta/:type/:id.json", metaHandler)
+	}
+	// We always register this route, because we don't know if the addon developer wants to use user data or not, as BehaviorHints.Configurable only indicates the configurability *via Stremio*
+	app.Get("/:userData/meta/:type/:id.json", metaHandler)
 	if a.opts.ConfigureHTMLfs != nil {
 		fsConfig := filesystem.Config{
 			Root: a.opts.ConfigureHTMLfs,
@@ -343,31 +504,70 @@ func (a *Addon) Run(stoppingChan chan bool) {
 	stoppingPtr := &stopping
 
 	addr := a.opts.BindAddr + ":" + strconv.Itoa(a.opts.Port)
-	logger.Info("Starting server", zap.String("address", addr))
+	logger.Info("Starting server", "address", addr)
 	go func() {
 		if err := app.Listen(addr); err != nil {
 			if !*stoppingPtr {
-				logger.Fatal("Couldn't start server", zap.Error(err))
+				fatal(logger, "Couldn't start server", "error", err)
 			} else {
-				logger.Fatal("Error in srv.ListenAndServe() during server shutdown (probably context deadline expired before the server could shutdown cleanly)", zap.Error(err))
+				fatal(logger, "Error in srv.ListenAndServe() during server shutdown (probably context deadline expired before the server could shutdown cleanly)", "error", err)
 			}
 		}
 	}()
 
+	// Optional reloading of the manifest and handlers
+
+	a.stateLock.Lock()
+	reloadCallback := a.reloadCallback
+	a.stateLock.Unlock()
+	if reloadCallback != nil {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+		go func() {
+			for range hup {
+				logger.Info("Received SIGHUP, reloading...")
+				if err := reloadCallback(); err != nil {
+					logger.Error("Couldn't reload", "error", err)
+				} else {
+					logger.Info("Finished reloading")
+				}
+			}
+		}()
+	}
+
 	// Graceful shutdown
 
 	c := make(chan os.Signal, 1)
 	// Accept SIGINT (Ctrl+C) and SIGTERM (`docker stop`)
 	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
 	sig := <-c
-	logger.Info("Received signal, shutting down server...", zap.Stringer("signal", sig))
+	logger.Info("Received signal, shutting down server...", "signal", sig)
 	*stoppingPtr = true
+	// Let load balancers know as early as possible that they shouldn't send new requests
+	health.setShuttingDown()
 	if stoppingChan != nil {
 		stoppingChan <- true
 	}
+	if a.opts.ShutdownDrainDelay > 0 {
+		logger.Info("Waiting for load balancers to notice the shutdown", "delay", a.opts.ShutdownDrainDelay)
+		time.Sleep(a.opts.ShutdownDrainDelay)
+	}
+	// Let running handlers know that they should stop, so that the graceful shutdown doesn't wait for their outbound calls
+	cancelBaseCtx()
 	// Graceful shutdown, waiting for all current requests to finish without accepting new ones.
 	if err := app.Shutdown(); err != nil {
-		logger.Fatal("Error shutting down server", zap.Error(err))
+		fatal(logger, "Error shutting down server", "error", err)
+	}
+	if a.closeCinemetaCache != nil {
+		if err := a.closeCinemetaCache(); err != nil {
+			logger.Error("Couldn't close Cinemeta cache", "error", err)
+		}
+	}
+	// Only close the access log file if we opened it
+	if rf, ok := a.accessLogWriter.(*rotatingFile); ok {
+		if err := rf.Close(); err != nil {
+			logger.Error("Couldn't close access log file", "error", err)
+		}
 	}
 	logger.Info("Finished shutting down server")
 }
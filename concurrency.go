@@ -0,0 +1,213 @@
+package stremio
+
+import (
+	"container/list"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+// ConcurrencyLimit limits the number of concurrent handler invocations for a resource.
+// Requests that exceed the limit wait in a bounded queue, in the order in which they arrived. When the queue is full,
+// or a request waited for too long, it gets a "503 Service Unavailable" response.
+// The zero value disables the limit.
+type ConcurrencyLimit struct {
+	// Maximum number of requests that are handled at the same time.
+	// 0 means no limit.
+	MaxInFlight int `config:"max_in_flight"`
+	// Maximum number of requests waiting for one of the in-flight requests to finish.
+	// 0 means requests are rejected immediately when MaxInFlight is reached.
+	MaxQueue int `config:"max_queue"`
+	// Maximum duration a request waits in the queue.
+	// 0 means it waits until a slot is free or the server shuts down.
+	QueueTimeout time.Duration `config:"queue_timeout"`
+}
+
+func (cl ConcurrencyLimit) enabled() bool {
+	return cl.MaxInFlight > 0
+}
+
+// concurrencyLimiter is a semaphore with a FIFO queue, so that waiting requests can't be overtaken by new ones.
+type concurrencyLimiter struct {
+	lock        *sync.Mutex
+	inFlight    int
+	maxInFlight int
+	// Channels of the waiting requests, oldest at the front. A channel is closed when its request gets a slot.
+	waiters  *list.List
+	maxQueue int
+}
+
+func newConcurrencyLimiter(limit ConcurrencyLimit) *concurrencyLimiter {
+	return &concurrencyLimiter{
+		lock:        &sync.Mutex{},
+		maxInFlight: limit.MaxInFlight,
+		waiters:     list.New(),
+		maxQueue:    limit.MaxQueue,
+	}
+}
+
+// acquire takes a slot if one is free and nobody is waiting for one.
+// Otherwise it returns a channel that's closed when the request gets a slot, or false if the queue is full.
+func (l *concurrencyLimiter) acquire() (*list.Element, bool) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if l.inFlight < l.maxInFlight && l.waiters.Len() == 0 {
+		l.inFlight++
+		return nil, true
+	} else if l.waiters.Len() >= l.maxQueue {
+		return nil, false
+	}
+	return l.waiters.PushBack(make(chan struct{})), true
+}
+
+// cancel removes the waiter from the queue.
+// It returns false if the waiter got a slot in the meantime, which it must then release.
+func (l *concurrencyLimiter) cancel(waiter *list.Element) bool {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	select {
+	case <-waiter.Value.(chan struct{}):
+		return false
+	default:
+	}
+	l.waiters.Remove(waiter)
+	return true
+}
+
+// release hands the slot to the oldest waiter or frees it.
+func (l *concurrencyLimiter) release() {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	if front := l.waiters.Front(); front != nil {
+		l.waiters.Remove(front)
+		close(front.Value.(chan struct{}))
+		return
+	}
+	l.inFlight--
+}
+
+func (l *concurrencyLimiter) stats() (inFlight, queued int) {
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	return l.inFlight, l.waiters.Len()
+}
+
+// concurrencySlot is a slot of a concurrency limiter that's held by the request and, if a handler is called in its own goroutine,
+// by that goroutine. It's released when both are done, so that handlers that keep running after a timeout still count towards the limit.
+// A nil slot can be used for requests without concurrency limit.
+type concurrencySlot struct {
+	lock    *sync.Mutex
+	holders int
+	release func()
+}
+
+// hold adds a holder of the slot.
+func (s *concurrencySlot) hold() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.holders++
+}
+
+// done removes a holder of the slot and releases the slot if it was the last one.
+func (s *concurrencySlot) done() {
+	if s == nil {
+		return
+	}
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.holders--
+	if s.holders == 0 {
+		s.release()
+	}
+}
+
+// concurrencyLimiters are all limiters by resource, for the gauges.
+// The gauges are process-wide, like the metrics set they're registered in,
+// so with multiple addons in one process they report the sum of their limiters.
+var (
+	concurrencyLimiters     = map[string][]*concurrencyLimiter{}
+	concurrencyLimitersLock = &sync.Mutex{}
+)
+
+func registerConcurrencyLimiterMetrics(resource string, l *concurrencyLimiter) {
+	concurrencyLimitersLock.Lock()
+	defer concurrencyLimitersLock.Unlock()
+	concurrencyLimiters[resource] = append(concurrencyLimiters[resource], l)
+	sum := func(f func(inFlight, queued int) int) float64 {
+		concurrencyLimitersLock.Lock()
+		defer concurrencyLimitersLock.Unlock()
+		total := 0
+		for _, l := range concurrencyLimiters[resource] {
+			total += f(l.stats())
+		}
+		return float64(total)
+	}
+	// Using GetOrCreate instead of New so that running multiple addons in one process (like in tests) doesn't panic.
+	// The callbacks don't capture the limiter, so it doesn't matter which one registered the gauge first.
+	metrics.GetOrCreateGauge(fmt.Sprintf(`handlers_in_flight{resource="%v"}`, resource), func() float64 {
+		return sum(func(inFlight, _ int) int { return inFlight })
+	})
+	metrics.GetOrCreateGauge(fmt.Sprintf(`handlers_queue_length{resource="%v"}`, resource), func() float64 {
+		return sum(func(_, queued int) int { return queued })
+	})
+}
+
+func createConcurrencyLimitMiddleware(resource string, limit ConcurrencyLimit, withMetrics bool, logger logging.Logger) fiber.Handler {
+	l := newConcurrencyLimiter(limit)
+	var rejected *metrics.Counter
+	if withMetrics {
+		registerConcurrencyLimiterMetrics(resource, l)
+		rejected = metrics.GetOrCreateCounter(fmt.Sprintf(`handlers_rejected_total{resource="%v"}`, resource))
+	}
+
+	reject := func(c *fiber.Ctx, reason string) error {
+		logger.Warn("Rejecting request due to concurrency limit", "resource", resource, "reason", reason)
+		if rejected != nil {
+			rejected.Inc()
+		}
+		return c.SendStatus(fiber.StatusServiceUnavailable)
+	}
+
+	return func(c *fiber.Ctx) error {
+		waiter, ok := l.acquire()
+		if !ok {
+			return reject(c, "queue full")
+		}
+		if waiter != nil {
+			var timeout <-chan time.Time
+			if limit.QueueTimeout > 0 {
+				timer := time.NewTimer(limit.QueueTimeout)
+				defer timer.Stop()
+				timeout = timer.C
+			}
+			reason := ""
+			select {
+			case <-waiter.Value.(chan struct{}):
+			case <-timeout:
+				reason = "queue timeout"
+			case <-c.Context().Done():
+				// Closed when the server shuts down
+				reason = "shutdown"
+			}
+			if reason != "" {
+				if l.cancel(waiter) {
+					return reject(c, reason)
+				}
+				// Got a slot at the same time, so use it
+			}
+		}
+		// The handler goroutine can outlive the request after a timeout, so it holds the slot as well (see callHandler)
+		slot := &concurrencySlot{lock: &sync.Mutex{}, holders: 1, release: l.release}
+		c.Locals("concurrencySlot", slot)
+		defer slot.done()
+		return c.Next()
+	}
+}
@@ -0,0 +1,136 @@
+package stremio
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestConcurrencyLimitMiddleware(t *testing.T) {
+	tests := []struct {
+		name  string
+		limit ConcurrencyLimit
+		// Status of the second request while the first one is in flight
+		wantStatus int
+	}{
+		{"queue full", ConcurrencyLimit{MaxInFlight: 1}, fiber.StatusServiceUnavailable},
+		{"queue timeout", ConcurrencyLimit{MaxInFlight: 1, MaxQueue: 1, QueueTimeout: 10 * time.Millisecond}, fiber.StatusServiceUnavailable},
+		{"queued", ConcurrencyLimit{MaxInFlight: 1, MaxQueue: 1}, fiber.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			started := make(chan struct{}, 2)
+			release := make(chan struct{})
+			app := fiber.New()
+			app.Use(createConcurrencyLimitMiddleware("stream", tc.limit, false, logging.NewNopLogger()))
+			app.Get("/", func(c *fiber.Ctx) error {
+				started <- struct{}{}
+				<-release
+				return c.SendStatus(fiber.StatusOK)
+			})
+
+			first := make(chan *http.Response)
+			go func() {
+				res, _ := app.Test(httptest.NewRequest("GET", "/", nil), -1)
+				first <- res
+			}()
+			<-started
+
+			second := make(chan *http.Response)
+			go func() {
+				res, _ := app.Test(httptest.NewRequest("GET", "/", nil), -1)
+				second <- res
+			}()
+			if tc.wantStatus == fiber.StatusOK {
+				// The second request waits in the queue until the first one is done
+				select {
+				case <-started:
+					t.Fatal("second request wasn't limited")
+				case <-time.After(50 * time.Millisecond):
+				}
+				close(release)
+			}
+
+			res := <-second
+			require.NotNil(t, res)
+			require.Equal(t, tc.wantStatus, res.StatusCode)
+			if tc.wantStatus != fiber.StatusOK {
+				close(release)
+			}
+			res = <-first
+			require.NotNil(t, res)
+			require.Equal(t, fiber.StatusOK, res.StatusCode)
+		})
+	}
+}
+
+func TestConcurrencyLimiterFIFO(t *testing.T) {
+	l := newConcurrencyLimiter(ConcurrencyLimit{MaxInFlight: 1, MaxQueue: 2})
+	_, ok := l.acquire()
+	require.True(t, ok)
+	first, ok := l.acquire()
+	require.True(t, ok)
+	require.NotNil(t, first)
+	second, ok := l.acquire()
+	require.True(t, ok)
+	require.NotNil(t, second)
+	_, ok = l.acquire()
+	require.False(t, ok)
+
+	// The slot goes to the oldest waiter instead of a new request
+	l.release()
+	require.Eventually(t, func() bool {
+		select {
+		case <-first.Value.(chan struct{}):
+			return true
+		default:
+			return false
+		}
+	}, time.Second, time.Millisecond)
+	newWaiter, ok := l.acquire()
+	require.True(t, ok)
+	require.NotNil(t, newWaiter)
+
+	// A waiter that gave up doesn't get the slot
+	require.True(t, l.cancel(second))
+	l.release()
+	<-newWaiter.Value.(chan struct{})
+	inFlight, queued := l.stats()
+	require.Equal(t, 1, inFlight)
+	require.Equal(t, 0, queued)
+}
+
+func TestConcurrencyLimitHandlerTimeout(t *testing.T) {
+	release := make(chan struct{})
+	// Ignores the cancellation of its context on purpose
+	streamHandler := convertStreamHandler(func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		<-release
+		return nil, nil
+	})
+	findHandler := func(t, id string) (handler, bool) { return streamHandler, true }
+	app := fiber.New()
+	app.Use(createConcurrencyLimitMiddleware("stream", ConcurrencyLimit{MaxInFlight: 1}, false, logging.NewNopLogger()))
+	app.Get("/stream/:type/:id.json", createStreamHandler(findHandler, 0, false, false, 10*time.Millisecond, TimeoutFallbackStatus, context.Background(), logging.NewNopLogger(), nil, false))
+
+	res, err := app.Test(httptest.NewRequest("GET", "/stream/movie/tt1.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusGatewayTimeout, res.StatusCode)
+
+	// The timed out handler is still running, so it still occupies the slot
+	res, err = app.Test(httptest.NewRequest("GET", "/stream/movie/tt2.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusServiceUnavailable, res.StatusCode)
+
+	close(release)
+	require.Eventually(t, func() bool {
+		res, err := app.Test(httptest.NewRequest("GET", "/stream/movie/tt3.json", nil))
+		return err == nil && res.StatusCode == fiber.StatusOK
+	}, time.Second, 10*time.Millisecond)
+}
@@ -1,10 +1,15 @@
 package stremio
 
 import (
+	"errors"
+	"fmt"
 	"net/http"
 	"time"
 
-	"go.uber.org/zap"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
 )
 
 // Options are the options that can be used to configure the addon.
@@ -12,90 +17,117 @@ type Options struct {
 	// The interface to bind to.
 	// "0.0.0.0" to bind to all interfaces. "localhost" to *exclude* requests from other machines.
 	// Default "localhost".
-	BindAddr string
+	BindAddr string `config:"bind_addr"`
 	// The port to listen on.
 	// Default 8080.
-	Port int
+	Port int `config:"port"`
 	// You can set a custom logger, or leave this empty to create a new one
 	// with sane defaults and the LoggingLevel in these options.
-	// If you already called `NewLogger()`, you should set that logger here.
+	// The logging package contains adapters for zap and log/slog, for example `logging.NewSlogLogger(slog.Default())`.
+	// If you already called `NewLogger()`, you should set that logger here, wrapped with `logging.NewZapLogger()`.
 	// Default nil.
-	Logger *zap.Logger
+	Logger logging.Logger
 	// The logging level.
 	// Only logs with the same or a higher log level will be shown.
 	// For example when you set it to "info", info, warn and error logs will be shown, but no debug logs.
 	// Accepts "debug", "info", "warn" and "error".
 	// Only required when not already setting the Logger in the options.
 	// Default "info".
-	LoggingLevel string
+	LoggingLevel string `config:"logging_level"`
 	// Configures zap's log encoding.
 	// "console" will format a log line console-friendly.
 	// "json" is better suited when using a centralized log solution like ELK, Graylog or Loki.
 	// Default "console".
-	LogEncoding string
+	LogEncoding string `config:"log_encoding"`
 	// Flag for indicating whether requests should be logged.
 	// Default false (meaning requests will be logged by default).
-	DisableRequestLogging bool
+	DisableRequestLogging bool `config:"disable_request_logging"`
 	// Flag for indicating whether IP addresses should be logged.
+	// The IPs are anonymized (last octet of IPv4 addresses and last 80 bits of IPv6 addresses removed) unless DisableLogRedaction is true.
 	// Default false.
-	LogIPs bool
+	LogIPs bool `config:"log_ips"`
 	// Flag for indicating whether the user agent header should be logged.
 	// Default false.
-	LogUserAgent bool
+	LogUserAgent bool `config:"log_user_agent"`
+	// Access log in the Common Log Format, Combined Log Format or JSON, written to a separate writer or rotating file.
+	// It's independent of the request logging via the Logger, so you can for example disable that and only use the access log.
+	// Default: no access log.
+	AccessLog AccessLogOptions `config:"access_log"`
+	// Flag for indicating whether the request log, access log and trace spans should contain the full URL and IPs.
+	// By default the user data segment of URLs is replaced by a fingerprint (a keyed hash of the user data, with a random key per process), as it can contain secrets like API tokens,
+	// and IPs are anonymized if LogIPs is true.
+	// Independent of this flag, decoded user data is only logged with the values of fields tagged with `stremio:"secret"` redacted.
+	// Default false.
+	DisableLogRedaction bool `config:"disable_log_redaction"`
 	// URL to redirect to when someone requests the root of the handler instead of the manifest, catalog, stream etc.
 	// When no value is set, it will lead to a "404 Not Found" response.
 	// Default "".
-	RedirectURL string
+	RedirectURL string `config:"redirect_url"`
 	// Flag for indicating whether you want to expose URL handlers for the Go profiler.
 	// The URLs are be the standard ones: "/debug/pprof/...".
 	// Default false.
-	Profiling bool
+	Profiling bool `config:"profiling"`
 	// Flag for indicating whether you want to collect and expose Prometheus metrics.
 	// The URL is the standard one: "/metrics".
 	// There's no credentials required for accessing it. If you expose deflix-stremio to the public,
 	// you might want to protect the metrics route in your reverse proxy.
+	// Besides request counts, it includes histograms of the request duration and handler duration per endpoint and type,
+	// the response size per endpoint, the number of in-flight requests and the number of "304 Not Modified" responses.
+	// When the meta middleware is used (see LogMediaName and PutMetaInContext), the time it adds to requests
+	// and metrics of the default Cinemeta client (requests, errors, latency and cache lookups) are included as well.
 	// Default false.
-	Metrics bool
+	Metrics bool `config:"metrics"`
+	// OpenTelemetry tracer provider for tracing requests.
+	// When set, a server span is created for each request, with child spans for decoding user data,
+	// the meta middleware (including the Cinemeta client's requests) and the handler call.
+	// The context that's passed to handlers contains the handler's span, so you can create your own child spans from it.
+	// For tests you can use a provider from the OpenTelemetry SDK with an in-memory or stdout exporter.
+	// Default nil (no tracing).
+	TracerProvider trace.TracerProvider
+	// Propagator for extracting the trace context from the headers of incoming requests.
+	// Only makes sense when also setting a TracerProvider.
+	// Default propagation.TraceContext{} (W3C Trace Context).
+	TracePropagator propagation.TextMapPropagator
 	// Duration of client/proxy-side cache for responses from the catalog endpoint.
 	// Helps reducing number of requsts and transferred data volume to/from the server.
 	// The result is not cached by the SDK on the server side, so if two *separate* users make a reqeust,
 	// and no proxy cached the response, your CatalogHandler will be called twice.
 	// Default 0.
-	CacheAgeCatalogs time.Duration
+	CacheAgeCatalogs time.Duration `config:"cache_age_catalogs"`
 	// Same as CacheAgeCatalogs, but for streams.
-	CacheAgeStreams time.Duration
+	CacheAgeStreams time.Duration `config:"cache_age_streams"`
 	// Same as CacheAgeCatalogs, but for meta.
-	CacheAgeMeta time.Duration
+	CacheAgeMeta time.Duration `config:"cache_age_meta"`
 	// Flag for indicating to proxies whether they are allowed to cache responses from the catalog endpoint.
 	// Default false.
-	CachePublicCatalogs bool
+	CachePublicCatalogs bool `config:"cache_public_catalogs"`
 	// Same as CachePublicCatalogs, but for streams.
-	CachePublicStreams bool
+	CachePublicStreams bool `config:"cache_public_streams"`
 	// Same as CachePublicCatalogs, but for meta.
-	CachePublicMeta bool
+	CachePublicMeta bool `config:"cache_public_meta"`
 	// Flag for indicating whether the "ETag" header should be set and the "If-None-Match" header checked.
 	// Helps reducing the transferred data volume from the server even further.
 	// Only makes sense when setting a non-zero CacheAgeCatalogs.
 	// Leads to a slight computational overhead due to every CatalogHandler result being hashed.
 	// Default false.
-	HandleEtagCatalogs bool
+	HandleEtagCatalogs bool `config:"handle_etag_catalogs"`
 	// Same as HandleEtagCatalogs, but for streams.
-	HandleEtagStreams bool
+	HandleEtagStreams bool `config:"handle_etag_streams"`
 	// Same as HandleEtagCatalogs, but for meta.
-	HandleEtagMeta bool
+	HandleEtagMeta bool `config:"handle_etag_meta"`
 	// Flag for indicating whether user data is Base64-encoded.
 	// As the user data is in the URL it needs to be the URL-safe Base64 encoding described in RFC 4648.
 	// When true, go-stremio first decodes the value before passing or unmarshalling it.
 	// Default false.
-	UserDataIsBase64 bool
+	UserDataIsBase64 bool `config:"user_data_is_base64"`
 	// Flag for indicating whether to look up the movie / TV show name by its IMDb ID and put it into the context.
 	// Only works for stream requests.
 	// Default false.
-	PutMetaInContext bool
+	PutMetaInContext bool `config:"put_meta_in_context"`
 	// Flag for indicating whether to include the movie / TV show name (and year) in the request log.
 	// Only works for stream requests.
 	// Default false.
-	LogMediaName bool
+	LogMediaName bool `config:"log_media_name"`
 	// Meta client for fetching movie and TV show info.
 	// Only relevant when using PutMetaInContext or LogMediaName.
 	// You can set it if you have already created one to share its in-memory cache for example,
@@ -106,7 +138,41 @@ type Options struct {
 	// Only required when not setting a MetaClient in the options already.
 	// Note that each response is cached for 30 days, so waiting a bit once per movie / TV show per 30 days is acceptable.
 	// Default 2 seconds.
-	CinemetaTimeout time.Duration
+	CinemetaTimeout time.Duration `config:"cinemeta_timeout"`
+	// Max number of movies / TV shows in the cache of the Cinemeta client.
+	// When it's reached, the least recently used entries are evicted. Entries expire after 30 days, but are kept for another 7 days in case Cinemeta is down.
+	// With a CinemetaCacheFile, it also limits the entries that are kept in memory, evicting the least recently written ones.
+	// Only relevant when using PutMetaInContext or LogMediaName and not setting a MetaClient in the options.
+	// Default 10,000.
+	CinemetaCacheSize int `config:"cinemeta_cache_size"`
+	// Path of a file for persisting the cache of the Cinemeta client, so that it survives restarts.
+	// Up to CinemetaCacheSize entries are loaded into memory at startup. The file is only written to by appending, and compacted automatically.
+	// Only relevant when using PutMetaInContext or LogMediaName and not setting a MetaClient in the options.
+	// Default "", meaning the cache is only kept in memory.
+	CinemetaCacheFile string `config:"cinemeta_cache_file"`
+	// Flag for indicating whether the readiness endpoint ("/health/ready") should check if Cinemeta is reachable.
+	// Only makes sense when using PutMetaInContext or LogMediaName.
+	// When setting a MetaClient, it must have a `Ping(context.Context) error` method like cinemeta.Client has.
+	// The result is cached for HealthCheckCinemetaCacheDuration.
+	// Default false.
+	HealthCheckCinemeta bool `config:"health_check_cinemeta"`
+	// Duration for which the result of the Cinemeta health check is reused, so that frequent readiness probes don't lead to many requests to Cinemeta.
+	// Only relevant when HealthCheckCinemeta is true.
+	// A negative value disables the caching.
+	// Default 30 seconds.
+	HealthCheckCinemetaCacheDuration time.Duration `config:"health_check_cinemeta_cache_duration"`
+	// Time between the readiness endpoint ("/health/ready") starting to respond with "503 Service Unavailable" and the server
+	// no longer accepting new connections when shutting down.
+	// During this time requests are still handled normally, so that load balancers can notice the failing readiness probe
+	// and stop sending new requests before the server closes its listener.
+	// It should be longer than the interval of the load balancer's readiness probe.
+	// Default 0, which is fine when there's no load balancer with readiness probes in front of the addon.
+	ShutdownDrainDelay time.Duration `config:"shutdown_drain_delay"`
+	// Flag for indicating whether NewAddon should check the manifest against the handlers with ValidateManifest,
+	// for example that each advertised type has a handler and each handler is advertised.
+	// All found problems are returned at once.
+	// Default false.
+	StrictManifestValidation bool `config:"strict_manifest_validation"`
 	// "File system" with HTML files that will be served for the "/configure" endpoint.
 	// Typically an `http.Dir`, which you can simply create with `http.Dir("/path/to/html/files")`.
 	// For using it with Go's embedding feature, you can either use `http.FS(embedFS)` directly,
@@ -121,15 +187,140 @@ type Options struct {
 	// URL-escaped values in the ID will be unescaped before matching.
 	// IMDb example: "^tt\\d{7,8}$" or `^tt\d{7,8}$`
 	// Default "".
-	StreamIDregex string
+	StreamIDregex string `config:"stream_id_regex"`
+	// Flag for indicating whether catalog, stream and meta requests should be validated against the manifest,
+	// before any handler is called or Cinemeta is requested.
+	// Requests for types that the resource doesn't declare, for IDs without any of the declared ID prefixes
+	// (of the resource or, if it has none, of the manifest), for undeclared catalogs or with catalog extras
+	// that are undeclared, not among the declared options or missing while required, are responded to with "400 Bad Request".
+	// Default false.
+	StrictRequestValidation bool `config:"strict_request_validation"`
+	// Rate limits for incoming requests, per client IP, per user and per resource.
+	// Requests exceeding a limit get a "429 Too Many Requests" response with a "Retry-After" header.
+	// Default: no rate limiting.
+	RateLimits RateLimitOptions `config:"rate_limits"`
+	// Limit of concurrent catalog handler invocations, with a bounded queue for requests exceeding the limit.
+	// Protects the addon from piling up goroutines (and running out of memory) when for example an upstream service is slow.
+	// When Metrics is true, the number of in-flight and queued requests and the number of rejections are exposed.
+	// Default: no limit.
+	ConcurrencyLimitCatalogs ConcurrencyLimit `config:"concurrency_limit_catalogs"`
+	// Same as ConcurrencyLimitCatalogs, but for streams.
+	ConcurrencyLimitStreams ConcurrencyLimit `config:"concurrency_limit_streams"`
+	// Same as ConcurrencyLimitCatalogs, but for meta.
+	ConcurrencyLimitMeta ConcurrencyLimit `config:"concurrency_limit_meta"`
+	// Timeout for catalog handlers.
+	// The context that's passed to handlers is cancelled when this timeout is reached,
+	// as well as when the client disconnects or the server shuts down.
+	// When the timeout is reached the addon responds according to HandlerTimeoutFallback, without waiting for the handler to return.
+	// Note that handlers should still respect the cancellation of the context, otherwise their goroutines keep running.
+	// Default 0 (no timeout).
+	HandlerTimeoutCatalogs time.Duration `config:"handler_timeout_catalogs"`
+	// Same as HandlerTimeoutCatalogs, but for streams.
+	HandlerTimeoutStreams time.Duration `config:"handler_timeout_streams"`
+	// Same as HandlerTimeoutCatalogs, but for meta.
+	HandlerTimeoutMeta time.Duration `config:"handler_timeout_meta"`
+	// Defines the response when a handler timeout is reached.
+	// Default TimeoutFallbackStatus ("504 Gateway Timeout").
+	HandlerTimeoutFallback TimeoutFallback `config:"handler_timeout_fallback"`
+}
+
+// validate checks the options for inconsistencies.
+// Checks that involve the manifest are done in NewAddon.
+func (opts Options) validate() error {
+	if (opts.CachePublicCatalogs && opts.CacheAgeCatalogs == 0) ||
+		(opts.CachePublicMeta && opts.CacheAgeMeta == 0) ||
+		(opts.CachePublicStreams && opts.CacheAgeStreams == 0) {
+		return errors.New("Enabling public caching only makes sense when also setting a cache age")
+	} else if (opts.HandleEtagCatalogs && opts.CacheAgeCatalogs == 0) ||
+		(opts.HandleEtagStreams && opts.CacheAgeStreams == 0) {
+		return errors.New("ETag handling only makes sense when also setting a cache age")
+	} else if opts.DisableRequestLogging && (opts.LogIPs || opts.LogUserAgent) {
+		return errors.New("Enabling IP or user agent logging doesn't make sense when disabling request logging")
+	} else if opts.DisableRequestLogging && opts.AccessLog.Format == "" && opts.DisableLogRedaction {
+		return errors.New("Disabling log redaction doesn't make sense when disabling request logging")
+	} else if opts.Logger != nil && opts.LoggingLevel != "" {
+		return errors.New("Setting a logging level in the options doesn't make sense when you already set a custom logger")
+	} else if opts.DisableRequestLogging && opts.LogMediaName {
+		return errors.New("Enabling media name logging doesn't make sense when disabling request logging")
+	} else if opts.MetaClient != nil && !opts.LogMediaName && !opts.PutMetaInContext {
+		return errors.New("Setting a meta client when neither logging the media name nor putting it in the context doesn't make sense")
+	} else if opts.HealthCheckCinemeta && !opts.LogMediaName && !opts.PutMetaInContext {
+		return errors.New("Checking Cinemeta's health doesn't make sense when neither logging the media name nor putting it in the context")
+	} else if !opts.HealthCheckCinemeta && opts.HealthCheckCinemetaCacheDuration != 0 {
+		return errors.New("Setting a cache duration for the Cinemeta health check only makes sense when also enabling the check")
+	} else if opts.ShutdownDrainDelay < 0 {
+		return errors.New("The shutdown drain delay must not be negative")
+	} else if opts.TracePropagator != nil && opts.TracerProvider == nil {
+		return errors.New("Setting a trace propagator only makes sense when also setting a tracer provider")
+	} else if opts.MetaClient != nil && opts.CinemetaTimeout != 0 {
+		return errors.New("Setting a Cinemeta timeout doesn't make sense when you already set a meta client")
+	} else if opts.CinemetaCacheSize < 0 {
+		return errors.New("The Cinemeta cache size must not be negative")
+	} else if opts.MetaClient != nil && opts.CinemetaCacheSize != 0 {
+		return errors.New("Setting a Cinemeta cache size doesn't make sense when you already set a meta client")
+	} else if opts.MetaClient != nil && opts.CinemetaCacheFile != "" {
+		return errors.New("Setting a Cinemeta cache file doesn't make sense when you already set a meta client")
+	}
+	for _, limit := range []ConcurrencyLimit{opts.ConcurrencyLimitCatalogs, opts.ConcurrencyLimitStreams, opts.ConcurrencyLimitMeta} {
+		if limit.MaxInFlight < 0 || limit.MaxQueue < 0 || limit.QueueTimeout < 0 {
+			return errors.New("Concurrency limits must not be negative")
+		} else if !limit.enabled() && (limit.MaxQueue != 0 || limit.QueueTimeout != 0) {
+			return errors.New("Setting a concurrency limit queue only makes sense when also setting MaxInFlight")
+		}
+	}
+	for resource := range opts.RateLimits.PerResource {
+		if resource != "catalog" && resource != "stream" && resource != "meta" {
+			return fmt.Errorf("Unknown resource %q in per-resource rate limits", resource)
+		}
+	}
+	if opts.HandlerTimeoutCatalogs < 0 || opts.HandlerTimeoutStreams < 0 || opts.HandlerTimeoutMeta < 0 {
+		return errors.New("Handler timeouts must not be negative")
+	}
+	if _, err := parseTrustedProxies(opts.RateLimits.TrustedProxies); err != nil {
+		return err
+	}
+	return opts.AccessLog.validate()
+}
+
+// setDefaults sets the default values for fields that aren't set.
+func (opts *Options) setDefaults() {
+	if opts.BindAddr == "" {
+		opts.BindAddr = DefaultOptions.BindAddr
+	}
+	if opts.Port == 0 {
+		opts.Port = DefaultOptions.Port
+	}
+	if opts.LoggingLevel == "" {
+		opts.LoggingLevel = DefaultOptions.LoggingLevel
+	}
+	if opts.LogEncoding == "" {
+		opts.LogEncoding = DefaultOptions.LogEncoding
+	}
+	if opts.CinemetaTimeout == 0 {
+		opts.CinemetaTimeout = DefaultOptions.CinemetaTimeout
+	}
+	if opts.CinemetaCacheSize == 0 {
+		opts.CinemetaCacheSize = DefaultOptions.CinemetaCacheSize
+	}
+	if opts.HealthCheckCinemeta && opts.HealthCheckCinemetaCacheDuration == 0 {
+		opts.HealthCheckCinemetaCacheDuration = DefaultOptions.HealthCheckCinemetaCacheDuration
+	}
+	if opts.RateLimits.Store == nil {
+		opts.RateLimits.Store = NewInMemoryRateLimitStore()
+	}
+	if opts.TracerProvider != nil && opts.TracePropagator == nil {
+		opts.TracePropagator = propagation.TraceContext{}
+	}
 }
 
 // DefaultOptions is an Options object with default values.
 // For fields that aren't set here the zero value is the default value.
 var DefaultOptions = Options{
-	BindAddr:        "localhost",
-	Port:            8080,
-	LoggingLevel:    "info",
-	LogEncoding:     "console",
-	CinemetaTimeout: 2 * time.Second,
+	BindAddr:                         "localhost",
+	Port:                             8080,
+	LoggingLevel:                     "info",
+	LogEncoding:                      "console",
+	CinemetaTimeout:                  2 * time.Second,
+	CinemetaCacheSize:                10000,
+	HealthCheckCinemetaCacheDuration: 30 * time.Second,
 }
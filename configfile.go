@@ -0,0 +1,381 @@
+package stremio
+
+import (
+	"bytes"
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v3"
+)
+
+// LoadOptions creates an Options object from a config file and environment variables.
+//
+// First the config file is read, unless the path is empty. Its format depends on the file extension:
+// ".yaml" or ".yml" for YAML, ".json" for JSON or ".toml" for TOML.
+// The keys are the snake case versions of the field names, like "bind_addr" or "cache_age_streams",
+// and nested options like the access log options are nested objects, like "access_log" with "format" etc.
+// Unknown keys lead to an error, so that typos don't go unnoticed.
+//
+// Then environment variables override the values from the file. Their names are the prefix followed by the upper case key,
+// with nested keys joined by an underscore. For example with the prefix "MYADDON_" the port is read from "MYADDON_PORT"
+// and the access log format from "MYADDON_ACCESS_LOG_FORMAT". Empty environment variables are ignored.
+//
+// Durations are strings like "10s" or "1m30s". Lists in environment variables are comma separated.
+// Maps, like the per-resource rate limits, can only be set in the config file.
+// Options that can't be represented as text, like the Logger or MetaClient, must be set on the returned object.
+//
+// The options are validated with the same checks as in NewAddon, except for the ones that involve the manifest.
+// Default values are not set, so that NewAddon can still distinguish between set and unset fields.
+func LoadOptions(envPrefix, configFile string) (Options, error) {
+	var opts Options
+	if configFile != "" {
+		values, err := readConfigFile(configFile)
+		if err != nil {
+			return Options{}, err
+		}
+		if err := setConfigValue(reflect.ValueOf(&opts).Elem(), values, ""); err != nil {
+			return Options{}, fmt.Errorf("Couldn't load config file: %w", err)
+		}
+	}
+	if err := setConfigFromEnv(reflect.ValueOf(&opts).Elem(), envPrefix); err != nil {
+		return Options{}, fmt.Errorf("Couldn't load environment variables: %w", err)
+	}
+	if err := opts.validate(); err != nil {
+		return Options{}, err
+	}
+	return opts, nil
+}
+
+// WriteConfig writes the effective configuration as YAML, with default values for fields that aren't set.
+// The output can be read by LoadOptions. Options that can't be represented as text, like the Logger, are omitted.
+// Unset fields whose defaults would conflict with those options (the logging level, Cinemeta timeout and Cinemeta cache size)
+// are omitted as well, so that the Logger and MetaClient can still be set on the loaded options.
+func (opts Options) WriteConfig(w io.Writer) error {
+	explicit := opts
+	opts.setDefaults()
+	values := configValue(reflect.ValueOf(opts)).(map[string]interface{})
+	if explicit.LoggingLevel == "" {
+		delete(values, "logging_level")
+	}
+	if explicit.CinemetaTimeout == 0 {
+		delete(values, "cinemeta_timeout")
+	}
+	if explicit.CinemetaCacheSize == 0 {
+		delete(values, "cinemeta_cache_size")
+	}
+	enc := yaml.NewEncoder(w)
+	if err := enc.Encode(values); err != nil {
+		return fmt.Errorf("Couldn't encode config: %w", err)
+	}
+	return enc.Close()
+}
+
+func readConfigFile(path string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't read config file: %w", err)
+	}
+	values := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &values)
+	case ".json":
+		// Numbers are decoded as json.Number so that large integers don't lose precision as float64
+		dec := json.NewDecoder(bytes.NewReader(content))
+		dec.UseNumber()
+		err = dec.Decode(&values)
+	case ".toml":
+		err = toml.Unmarshal(content, &values)
+	default:
+		return nil, fmt.Errorf("Unknown config file extension %q", ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't parse config file: %w", err)
+	}
+	return values, nil
+}
+
+var (
+	durationType        = reflect.TypeOf(time.Duration(0))
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+	textMarshalerType   = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// configFields calls f for each field of the struct that has a "config" tag.
+func configFields(v reflect.Value, f func(key string, field reflect.Value) error) error {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		key := t.Field(i).Tag.Get("config")
+		if key == "" {
+			continue
+		}
+		if err := f(key, v.Field(i)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isConfigStruct returns true for structs whose fields are set individually, as opposed to types that are set from a single text value.
+func isConfigStruct(t reflect.Type) bool {
+	return t.Kind() == reflect.Struct && !reflect.PtrTo(t).Implements(textUnmarshalerType)
+}
+
+// setConfigValue sets v to the raw value from a config file or environment variable.
+// The path is the config key of v, for error messages.
+func setConfigValue(v reflect.Value, raw interface{}, path string) error {
+	t := v.Type()
+
+	// Types with their own text representation, like TimeoutFallback
+	if reflect.PtrTo(t).Implements(textUnmarshalerType) {
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("%v: expected a string, got %T", path, raw)
+		}
+		if err := v.Addr().Interface().(encoding.TextUnmarshaler).UnmarshalText([]byte(s)); err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+		return nil
+	}
+	if t == durationType {
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("%v: expected a duration string like \"10s\", got %T", path, raw)
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return fmt.Errorf("%v: %w", path, err)
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v: expected an object, got %T", path, raw)
+		}
+		known := map[string]bool{}
+		err := configFields(v, func(key string, field reflect.Value) error {
+			known[key] = true
+			if fieldRaw, ok := m[key]; ok {
+				return setConfigValue(field, fieldRaw, joinConfigPath(path, key))
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		for key := range m {
+			if !known[key] {
+				return fmt.Errorf("Unknown config key %q", joinConfigPath(path, key))
+			}
+		}
+	case reflect.Map:
+		m, ok := raw.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("%v: expected an object, got %T", path, raw)
+		}
+		result := reflect.MakeMapWithSize(t, len(m))
+		for key, elemRaw := range m {
+			elem := reflect.New(t.Elem()).Elem()
+			if err := setConfigValue(elem, elemRaw, joinConfigPath(path, key)); err != nil {
+				return err
+			}
+			result.SetMapIndex(reflect.ValueOf(key), elem)
+		}
+		v.Set(result)
+	case reflect.Slice:
+		var elems []interface{}
+		switch r := raw.(type) {
+		case []interface{}:
+			elems = r
+		case string:
+			// Comma separated in environment variables
+			for _, s := range strings.Split(r, ",") {
+				elems = append(elems, strings.TrimSpace(s))
+			}
+		default:
+			return fmt.Errorf("%v: expected a list, got %T", path, raw)
+		}
+		result := reflect.MakeSlice(t, len(elems), len(elems))
+		for i, elemRaw := range elems {
+			if err := setConfigValue(result.Index(i), elemRaw, fmt.Sprintf("%v[%d]", path, i)); err != nil {
+				return err
+			}
+		}
+		v.Set(result)
+	case reflect.String:
+		s, ok := raw.(string)
+		if !ok {
+			return fmt.Errorf("%v: expected a string, got %T", path, raw)
+		}
+		v.SetString(s)
+	case reflect.Bool:
+		switch r := raw.(type) {
+		case bool:
+			v.SetBool(r)
+		case string:
+			b, err := strconv.ParseBool(r)
+			if err != nil {
+				return fmt.Errorf("%v: %w", path, err)
+			}
+			v.SetBool(b)
+		default:
+			return fmt.Errorf("%v: expected a bool, got %T", path, raw)
+		}
+	case reflect.Int, reflect.Int64:
+		i, err := configInt(raw, path)
+		if err != nil {
+			return err
+		}
+		v.SetInt(i)
+	case reflect.Float64:
+		f, err := configNumber(raw, path)
+		if err != nil {
+			return err
+		}
+		v.SetFloat(f)
+	default:
+		return fmt.Errorf("%v: unsupported type %v", path, t)
+	}
+	return nil
+}
+
+// configInt converts the numbers of the different config file decoders as well as strings to an int64.
+// Integers aren't converted via float64, so that large values don't lose precision.
+func configInt(raw interface{}, path string) (int64, error) {
+	var s string
+	switch r := raw.(type) {
+	case int:
+		return int64(r), nil
+	case int64:
+		return r, nil
+	case uint64:
+		if r > math.MaxInt64 {
+			return 0, fmt.Errorf("%v: %v is out of range", path, r)
+		}
+		return int64(r), nil
+	case float64:
+		if r != math.Trunc(r) || r < math.MinInt64 || r >= math.MaxInt64 {
+			return 0, fmt.Errorf("%v: expected an integer, got %v", path, r)
+		}
+		return int64(r), nil
+	case json.Number:
+		s = r.String()
+	case string:
+		s = r
+	default:
+		return 0, fmt.Errorf("%v: expected an integer, got %T", path, raw)
+	}
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%v: expected an integer: %w", path, err)
+	}
+	return i, nil
+}
+
+// configNumber converts the numbers of the different config file decoders as well as strings to a float64.
+func configNumber(raw interface{}, path string) (float64, error) {
+	switch r := raw.(type) {
+	case int:
+		return float64(r), nil
+	case int64:
+		return float64(r), nil
+	case float64:
+		return r, nil
+	case json.Number:
+		f, err := r.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("%v: %w", path, err)
+		}
+		return f, nil
+	case string:
+		f, err := strconv.ParseFloat(r, 64)
+		if err != nil {
+			return 0, fmt.Errorf("%v: %w", path, err)
+		}
+		return f, nil
+	default:
+		return 0, fmt.Errorf("%v: expected a number, got %T", path, raw)
+	}
+}
+
+func joinConfigPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// setConfigFromEnv sets the fields of the struct from environment variables.
+func setConfigFromEnv(v reflect.Value, prefix string) error {
+	return configFields(v, func(key string, field reflect.Value) error {
+		name := prefix + strings.ToUpper(key)
+		if isConfigStruct(field.Type()) {
+			return setConfigFromEnv(field, name+"_")
+		} else if field.Kind() == reflect.Map {
+			return nil
+		}
+		val, ok := os.LookupEnv(name)
+		if !ok || val == "" {
+			return nil
+		}
+		return setConfigValue(field, val, name)
+	})
+}
+
+// configValue returns the value of v like it's represented in a config file.
+func configValue(v reflect.Value) interface{} {
+	t := v.Type()
+	if t.Implements(textMarshalerType) {
+		text, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			return err.Error()
+		}
+		return string(text)
+	} else if t == durationType {
+		return time.Duration(v.Int()).String()
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		m := map[string]interface{}{}
+		// The callback never returns an error
+		_ = configFields(v, func(key string, field reflect.Value) error {
+			// Unset maps and lists are omitted, because an empty one can have a different meaning
+			if (field.Kind() == reflect.Map || field.Kind() == reflect.Slice) && field.IsNil() {
+				return nil
+			}
+			m[key] = configValue(field)
+			return nil
+		})
+		return m
+	case reflect.Map:
+		m := make(map[string]interface{}, v.Len())
+		iter := v.MapRange()
+		for iter.Next() {
+			m[iter.Key().String()] = configValue(iter.Value())
+		}
+		return m
+	case reflect.Slice:
+		l := make([]interface{}, v.Len())
+		for i := range l {
+			l[i] = configValue(v.Index(i))
+		}
+		return l
+	default:
+		return v.Interface()
+	}
+}
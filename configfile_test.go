@@ -0,0 +1,126 @@
+package stremio
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestLoadOptions(t *testing.T) {
+	files := map[string]string{
+		"config.yaml": `
+port: 8081
+cache_age_streams: 1h
+handler_timeout_fallback: cache
+access_log:
+  format: json
+  fields: [time, url]
+rate_limits:
+  per_resource:
+    stream:
+      rate: 0.5
+      burst: 2
+`,
+		"config.json": `{"port": 8081, "cache_age_streams": "1h", "handler_timeout_fallback": "cache", "access_log": {"format": "json", "fields": ["time", "url"]}, "rate_limits": {"per_resource": {"stream": {"rate": 0.5, "burst": 2}}}}`,
+		"config.toml": `
+port = 8081
+cache_age_streams = "1h"
+handler_timeout_fallback = "cache"
+[access_log]
+format = "json"
+fields = ["time", "url"]
+[rate_limits.per_resource.stream]
+rate = 0.5
+burst = 2
+`,
+	}
+	dir := t.TempDir()
+	for name, content := range files {
+		t.Run(name, func(t *testing.T) {
+			path := filepath.Join(dir, name)
+			require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+			// Environment variables override the file
+			t.Setenv("TEST_PORT", "9090")
+			t.Setenv("TEST_ACCESS_LOG_FORMAT", "")
+			t.Setenv("TEST_RATE_LIMITS_TRUSTED_PROXIES", "10.0.0.0/8, 127.0.0.1")
+
+			opts, err := LoadOptions("TEST_", path)
+			require.NoError(t, err)
+			require.Equal(t, 9090, opts.Port)
+			require.Equal(t, time.Hour, opts.CacheAgeStreams)
+			require.Equal(t, TimeoutFallbackCache, opts.HandlerTimeoutFallback)
+			require.Equal(t, "json", opts.AccessLog.Format)
+			require.Equal(t, []string{"time", "url"}, opts.AccessLog.Fields)
+			require.Equal(t, RateLimit{Rate: 0.5, Burst: 2}, opts.RateLimits.PerResource["stream"])
+			require.Equal(t, []string{"10.0.0.0/8", "127.0.0.1"}, opts.RateLimits.TrustedProxies)
+		})
+	}
+}
+
+func TestLoadOptionsErrors(t *testing.T) {
+	dir := t.TempDir()
+	for content, errMsg := range map[string]string{
+		"prot: 8080":                  `Unknown config key "prot"`,
+		"cache_age_streams: 60":       "expected a duration",
+		"cache_public_streams: true":  "public caching",
+		"access_log: {format: xml}":   "Unknown access log format",
+		"handler_timeout_fallback: x": "Unknown timeout fallback",
+	} {
+		path := filepath.Join(dir, "config.yml")
+		require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+		_, err := LoadOptions("TEST_", path)
+		require.ErrorContains(t, err, errMsg)
+	}
+}
+
+func TestWriteConfig(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Options{HandlerTimeoutStreams: 5 * time.Second}.WriteConfig(&buf))
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	opts, err := LoadOptions("TEST_", path)
+	require.NoError(t, err)
+	require.Equal(t, DefaultOptions.Port, opts.Port)
+	require.Equal(t, 5*time.Second, opts.HandlerTimeoutStreams)
+}
+
+func TestWriteConfigRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, Options{LogMediaName: true, UserDataIsBase64: true}.WriteConfig(&buf))
+	require.NotContains(t, buf.String(), "logging_level")
+	require.NotContains(t, buf.String(), "cinemeta_timeout")
+	require.NotContains(t, buf.String(), "cinemeta_cache_size")
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, buf.Bytes(), 0644))
+	opts, err := LoadOptions("TEST_", path)
+	require.NoError(t, err)
+	require.True(t, opts.UserDataIsBase64)
+
+	// Options that can't be in the config file can still be set
+	opts.Logger = logging.NewNopLogger()
+	opts.MetaClient = fakeMetaFetcher{}
+	require.NoError(t, opts.validate())
+}
+
+func TestLoadOptionsLargeIntegers(t *testing.T) {
+	dir := t.TempDir()
+	// 2^53 + 1 can't be represented as float64
+	content := `{"access_log": {"format": "json", "file": "access.log", "max_size": 9007199254740993}}`
+	path := filepath.Join(dir, "config.json")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	opts, err := LoadOptions("TEST_", path)
+	require.NoError(t, err)
+	require.Equal(t, int64(9007199254740993), opts.AccessLog.MaxSize)
+
+	require.NoError(t, os.WriteFile(path, []byte(`{"port": 8080.5}`), 0644))
+	_, err = LoadOptions("TEST_", path)
+	require.ErrorContains(t, err, "expected an integer")
+}
@@ -0,0 +1,129 @@
+package stremio
+
+import (
+	"context"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+// RequestIDFromContext returns the ID of the request.
+// The ID is either taken from the request's "X-Request-ID" header or generated by the addon.
+// It works with the context that's passed to handlers and the manifest callback,
+// as well as with the Fiber context in custom middlewares and endpoints (via `c.Context()`).
+// It returns an empty string if the context doesn't contain a request ID.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value("requestID").(string)
+	return requestID
+}
+
+// LoggerFromContext returns the addon's logger with the request ID already added as field,
+// so that your log lines can be correlated with the addon's request log.
+// Like RequestIDFromContext it works with handler contexts and Fiber contexts.
+// It returns a no-op logger if the context doesn't contain a logger.
+func LoggerFromContext(ctx context.Context) logging.Logger {
+	if logger, ok := ctx.Value("logger").(logging.Logger); ok {
+		return logger
+	}
+	return logging.NewNopLogger()
+}
+
+// CatalogExtraFromContext returns the extra of a catalog request, like {"genre": "Action", "skip": "100"},
+// as declared in the catalog's CatalogItem.Extra in the manifest.
+// It works with the context that's passed to catalog handlers.
+// It returns nil for requests without extra.
+func CatalogExtraFromContext(ctx context.Context) map[string]string {
+	extra, _ := ctx.Value("catalogExtra").(map[string]string)
+	return extra
+}
+
+// withRequestID adds the request ID as field to the logger if the request ID middleware put one in the locals.
+func withRequestID(c *fiber.Ctx, logger logging.Logger) logging.Logger {
+	if requestID, ok := c.Locals("requestID").(string); ok {
+		return logger.With("requestID", requestID)
+	}
+	return logger
+}
+
+// handlerContext is the context that's passed to handlers.
+// It's cancelled when the handler timeout is reached, the client disconnects or the server shuts down.
+// It carries a snapshot of the request's local values (like the meta that the meta middleware puts there),
+// because the Fiber / fasthttp context is reused for other requests as soon as the addon responded,
+// which can happen before the handler returns (after a timeout).
+type handlerContext struct {
+	context.Context
+	values map[interface{}]interface{}
+}
+
+// Value returns the value of the wrapped context or, if that's nil, the request's local value.
+func (ctx handlerContext) Value(key interface{}) interface{} {
+	if v := ctx.Context.Value(key); v != nil {
+		return v
+	}
+	return ctx.values[key]
+}
+
+// newHandlerContext creates a context for calling a handler.
+// It's derived from baseCtx, which is cancelled when the server shuts down.
+// A timeout of 0 means no timeout.
+// The returned cancel function must be called when the request is done, which also stops watching for a client disconnect.
+func newHandlerContext(c *fiber.Ctx, baseCtx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	values := map[interface{}]interface{}{}
+	c.Context().VisitUserValuesAll(func(key, value interface{}) {
+		values[key] = value
+	})
+
+	var ctx context.Context
+	var cancel context.CancelFunc
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(baseCtx, timeout)
+	} else {
+		ctx, cancel = context.WithCancel(baseCtx)
+	}
+	watchDisconnect(ctx, c.Context().Conn(), cancel)
+
+	return handlerContext{
+		Context: ctx,
+		values:  values,
+	}, cancel
+}
+
+// callHandler calls the handler and returns its result.
+// When the context has a deadline, the handler is called in a separate goroutine and callHandler returns
+// as soon as the deadline is reached, even if the handler is still running.
+// In that case the goroutine keeps holding the request's concurrency limiter slot until the handler returns.
+func callHandler(ctx context.Context, h handler, id string, userData interface{}) (interface{}, error) {
+	if _, ok := ctx.Deadline(); !ok {
+		return h(ctx, id, userData)
+	}
+
+	type result struct {
+		res interface{}
+		err error
+	}
+	resChan := make(chan result, 1)
+	slot, _ := ctx.Value("concurrencySlot").(*concurrencySlot)
+	slot.hold()
+	go func() {
+		defer slot.done()
+		// Fiber's recover middleware can't catch panics in other goroutines
+		defer func() {
+			if r := recover(); r != nil {
+				resChan <- result{err: fmt.Errorf("Handler panicked: %v\n%s", r, debug.Stack())}
+			}
+		}()
+		res, err := h(ctx, id, userData)
+		resChan <- result{res: res, err: err}
+	}()
+
+	select {
+	case r := <-resChan:
+		return r.res, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
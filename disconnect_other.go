@@ -0,0 +1,13 @@
+//go:build !linux && !darwin && !freebsd
+// +build !linux,!darwin,!freebsd
+
+package stremio
+
+import (
+	"context"
+	"net"
+)
+
+// watchDisconnect is a no-op on platforms where we can't peek into the socket.
+// Handler contexts are then only cancelled on timeout and server shutdown.
+func watchDisconnect(ctx context.Context, conn net.Conn, cancel context.CancelFunc) {}
@@ -0,0 +1,52 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package stremio
+
+import (
+	"context"
+	"net"
+	"syscall"
+	"time"
+)
+
+// disconnectPollInterval is the interval in which the client connection is checked while a handler is running.
+const disconnectPollInterval = 500 * time.Millisecond
+
+// watchDisconnect calls cancel when the client closes the connection before ctx is done.
+// fasthttp doesn't notify about client disconnects, so we peek into the socket without consuming any data.
+// A read of 0 bytes without error (EOF) or an error like ECONNRESET means the client closed the connection.
+func watchDisconnect(ctx context.Context, conn net.Conn, cancel context.CancelFunc) {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return
+	}
+	rawConn, err := sc.SyscallConn()
+	if err != nil {
+		return
+	}
+
+	go func() {
+		ticker := time.NewTicker(disconnectPollInterval)
+		defer ticker.Stop()
+		buf := make([]byte, 1)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			closed := false
+			err := rawConn.Read(func(fd uintptr) bool {
+				n, _, err := syscall.Recvfrom(int(fd), buf, syscall.MSG_PEEK|syscall.MSG_DONTWAIT)
+				closed = (n == 0 && err == nil) || (err != nil && err != syscall.EAGAIN && err != syscall.EINTR)
+				// Returning true prevents the runtime from waiting for the socket to become readable
+				return true
+			})
+			if err != nil || closed {
+				cancel()
+				return
+			}
+		}
+	}()
+}
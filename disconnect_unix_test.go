@@ -0,0 +1,47 @@
+//go:build linux || darwin || freebsd
+// +build linux darwin freebsd
+
+package stremio
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestClientDisconnectCancelsHandler(t *testing.T) {
+	handlerErrs := make(chan error, 1)
+	streamHandler := convertStreamHandler(func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		<-ctx.Done()
+		handlerErrs <- ctx.Err()
+		return nil, ctx.Err()
+	})
+	app := fiber.New(fiber.Config{DisableStartupMessage: true})
+	app.Get("/stream/:type/:id.json", createStreamHandler(func(t, id string) (handler, bool) { return streamHandler, true }, 0, false, false, 0, 0, context.Background(), logging.NewNopLogger(), nil, false))
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go func() { _ = app.Listener(ln) }()
+	defer func() { _ = app.Shutdown() }()
+
+	conn, err := net.Dial("tcp", ln.Addr().String())
+	require.NoError(t, err)
+	_, err = conn.Write([]byte("GET /stream/movie/tt1.json HTTP/1.1\r\nHost: localhost\r\n\r\n"))
+	require.NoError(t, err)
+	// Give the server time to call the handler
+	time.Sleep(100 * time.Millisecond)
+	require.NoError(t, conn.Close())
+
+	select {
+	case err := <-handlerErrs:
+		require.ErrorIs(t, err, context.Canceled)
+	case <-time.After(3 * disconnectPollInterval):
+		t.Fatal("Handler context wasn't cancelled after the client disconnected")
+	}
+}
@@ -12,6 +12,7 @@ import (
 
 	"github.com/deflix-tv/go-stremio"
 	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+	"github.com/deflix-tv/go-stremio/pkg/logging"
 )
 
 var (
@@ -73,7 +74,7 @@ var allowedUsers = []customer{
 // which is the URL-safe Base64 encoded string of `{"userId":"123","token":"abc","preferredStreamType":"http"}`.
 type customer struct {
 	UserID              string `json:"userId"`
-	Token               string `json:"token"`
+	Token               string `json:"token" stremio:"secret"` // The tag prevents the token from being logged
 	PreferredStreamType string `json:"preferredStreamType"`
 }
 
@@ -90,8 +91,8 @@ func main() {
 	streamHandlers := map[string]stremio.StreamHandler{"movie": movieHandler}
 
 	options := stremio.Options{
-		// We already have a logger
-		Logger: logger,
+		// We already have a logger, which we wrap so the addon can use it
+		Logger: logging.NewZapLogger(logger),
 		// Our addon uses Base64 encoded user data
 		UserDataIsBase64: true,
 		// We want to access the cinemeta.Meta from the context
@@ -159,7 +160,8 @@ func createMovieHandler(logger *zap.Logger) stremio.StreamHandler {
 			// No need to check if userData is nil or if the conversion worked, because our custom auth middleware did that already.
 			u, _ := userData.(*customer)
 
-			logger.Info("User requested stream", zap.String("userID", u.UserID))
+			// The logger from the context contains the request ID, so this log line can be correlated with the request log
+			stremio.LoggerFromContext(ctx).Info("User requested stream", "userID", u.UserID)
 
 			// Return different streams depending on the user's preference
 			switch u.PreferredStreamType {
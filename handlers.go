@@ -14,7 +14,12 @@ import (
 
 	"github.com/cespare/xxhash/v2"
 	"github.com/gofiber/fiber/v2"
-	"go.uber.org/zap"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+	"github.com/deflix-tv/go-stremio/pkg/videoid"
 )
 
 type customEndpoint struct {
@@ -23,31 +28,20 @@ type customEndpoint struct {
 	handler fiber.Handler
 }
 
-func createHealthHandler(logger *zap.Logger) fiber.Handler {
+func createHealthHandler(logger logging.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		logger.Debug("healthHandler called")
 		return c.SendString("OK")
 	}
 }
 
-func createManifestHandler(manifest Manifest, logger *zap.Logger, manifestCallback ManifestCallback, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
-	// When there's user data we want Stremio to show the "Install" button, which it only does when "configurationRequired" is false.
-	// To not change the boolean value of the manifest object on the fly and thus mess with a single object across concurrent goroutines, we copy it and return two different objects.
-	// Note that this manifest copy has some values shallowly copied, but `BehaviorHints.ConfigurationRequired` is a simple type and thus a real copy.
-	configuredManifest := manifest
-	configuredManifest.BehaviorHints.ConfigurationRequired = false
-
-	manifestBody, err := json.Marshal(manifest)
-	if err != nil {
-		logger.Fatal("Couldn't marshal manifest", zap.Error(err))
-	}
-	configuredManifestBody, err := json.Marshal(configuredManifest)
-	if err != nil {
-		logger.Fatal("Couldn't marshal configured manifest", zap.Error(err))
-	}
-
+// createManifestHandler creates a handler for manifest requests.
+// The manifest is taken from the current state for each request, so that it can be replaced at runtime.
+func createManifestHandler(getState func() *addonState, logger logging.Logger, manifestCallback ManifestCallback, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
 	return func(c *fiber.Ctx) error {
+		logger := withRequestID(c, logger)
 		logger.Debug("manifestHandler called")
+		state := getState()
 
 		// First call the callback so the SDK user can prevent further processing
 		var userData interface{}
@@ -64,14 +58,17 @@ func createManifestHandler(manifest Manifest, logger *zap.Logger, manifestCallba
 			if userDataType == nil {
 				userData = userDataString
 			} else {
+				_, span := startSpan(c, "decode user data")
 				var err error
-				if userData, err = decodeUserData(userDataString, userDataType, logger, userDataIsBase64); err != nil {
+				userData, err = decodeUserData(userDataString, userDataType, logger, userDataIsBase64)
+				endSpan(span, err)
+				if err != nil {
 					return c.SendStatus(fiber.StatusBadRequest)
 				}
 			}
 		}
 		if manifestCallback != nil {
-			manifestClone := manifest.clone()
+			manifestClone := state.manifest.clone()
 			if status := manifestCallback(c.Context(), &manifestClone, userData); status >= 400 {
 				return c.SendStatus(status)
 			}
@@ -82,47 +79,35 @@ func createManifestHandler(manifest Manifest, logger *zap.Logger, manifestCallba
 			// Probably no performance gain when checking deep equality of original vs cloned manifest to skip potentially unnecessary JSON encoding.
 			clonedManifestBody, err := json.Marshal(manifestClone)
 			if err != nil {
-				logger.Fatal("Couldn't marshal cloned manifest", zap.Error(err))
+				fatal(logger, "Couldn't marshal cloned manifest", "error", err)
 			}
-			logger.Debug("Responding", zap.ByteString("body", clonedManifestBody))
+			logger.Debug("Responding", "body", byteString(clonedManifestBody))
 			c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
 			return c.Send(clonedManifestBody)
 		}
 
 		if configured {
-			logger.Debug("Responding", zap.ByteString("body", configuredManifestBody))
+			logger.Debug("Responding", "body", byteString(state.configuredManifestBody))
 			c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
-			return c.Send(configuredManifestBody)
+			return c.Send(state.configuredManifestBody)
 		} else {
-			logger.Debug("Responding", zap.ByteString("body", manifestBody))
+			logger.Debug("Responding", "body", byteString(state.manifestBody))
 			c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
-			return c.Send(manifestBody)
+			return c.Send(state.manifestBody)
 		}
 	}
 }
 
-func createCatalogHandler(catalogHandlers map[string]CatalogHandler, cacheAge time.Duration, cachePublic, handleEtag bool, logger *zap.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
-	handlers := make(map[string]handler, len(catalogHandlers))
-	for k, v := range catalogHandlers {
-		handlers[k] = convertCatalogHandler(v)
-	}
-	return createHandler("catalog", handlers, []byte("metas"), cacheAge, cachePublic, handleEtag, logger, userDataType, userDataIsBase64)
+func createCatalogHandler(findHandler func(t, id string) (handler, bool), cacheAge time.Duration, cachePublic, handleEtag bool, timeout time.Duration, timeoutFallback TimeoutFallback, baseCtx context.Context, logger logging.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
+	return createHandler("catalog", findHandler, []byte("metas"), cacheAge, cachePublic, handleEtag, timeout, timeoutFallback, baseCtx, logger, userDataType, userDataIsBase64)
 }
 
-func createStreamHandler(streamHandlers map[string]StreamHandler, cacheAge time.Duration, cachePublic, handleEtag bool, logger *zap.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
-	handlers := make(map[string]handler, len(streamHandlers))
-	for k, v := range streamHandlers {
-		handlers[k] = convertStreamHandler(v)
-	}
-	return createHandler("stream", handlers, []byte("streams"), cacheAge, cachePublic, handleEtag, logger, userDataType, userDataIsBase64)
+func createStreamHandler(findHandler func(t, id string) (handler, bool), cacheAge time.Duration, cachePublic, handleEtag bool, timeout time.Duration, timeoutFallback TimeoutFallback, baseCtx context.Context, logger logging.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
+	return createHandler("stream", findHandler, []byte("streams"), cacheAge, cachePublic, handleEtag, timeout, timeoutFallback, baseCtx, logger, userDataType, userDataIsBase64)
 }
 
-func createMetaHandler(metaHandlers map[string]MetaHandler, cacheAge time.Duration, cachePublic, handleEtag bool, logger *zap.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
-	handlers := make(map[string]handler, len(metaHandlers))
-	for k, v := range metaHandlers {
-		handlers[k] = convertMetaHandler(v)
-	}
-	return createHandler("meta", handlers, []byte("meta"), cacheAge, cachePublic, handleEtag, logger, userDataType, userDataIsBase64)
+func createMetaHandler(findHandler func(t, id string) (handler, bool), cacheAge time.Duration, cachePublic, handleEtag bool, timeout time.Duration, timeoutFallback TimeoutFallback, baseCtx context.Context, logger logging.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
+	return createHandler("meta", findHandler, []byte("meta"), cacheAge, cachePublic, handleEtag, timeout, timeoutFallback, baseCtx, logger, userDataType, userDataIsBase64)
 }
 
 func convertCatalogHandler(h CatalogHandler) handler {
@@ -143,11 +128,30 @@ func convertMetaHandler(h MetaHandler) handler {
 	}
 }
 
+// parseCatalogExtra parses the extra of a catalog request, like "genre=Action&skip=100".
+// Stremio URL-encodes the values, so they're decoded here.
+func parseCatalogExtra(extraString string) (map[string]string, error) {
+	values, err := url.ParseQuery(extraString)
+	if err != nil {
+		return nil, err
+	}
+	extra := make(map[string]string, len(values))
+	for k, v := range values {
+		if len(v) > 1 {
+			return nil, fmt.Errorf("Extra %q is set multiple times", k)
+		}
+		extra[k] = v[0]
+	}
+	return extra, nil
+}
+
 // Common handler (same signature as both catalog, stream and meta handler)
 type handler func(ctx context.Context, id string, userData interface{}) (interface{}, error)
 
-func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey []byte, cacheAge time.Duration, cachePublic, handleEtag bool, logger *zap.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
-	handlerName = handlerName + "Handler"
+// createHandler creates a handler for catalog, stream or meta requests.
+// The handlers are taken from the current state for each request, so that they can be replaced at runtime.
+func createHandler(resource string, findHandler func(t, id string) (handler, bool), jsonArrayKey []byte, cacheAge time.Duration, cachePublic, handleEtag bool, timeout time.Duration, timeoutFallback TimeoutFallback, baseCtx context.Context, logger logging.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
+	handlerName := resource + "Handler"
 	handlerLogMsg := handlerName + " called"
 
 	var cacheHeaderVal string
@@ -161,43 +165,108 @@ func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey
 		}
 	}
 
-	logger = logger.With(zap.String("handler", handlerName))
+	var fallbackResponses *fallbackCache
+	if timeoutFallback == TimeoutFallbackCache {
+		fallbackResponses = newFallbackCache()
+	}
+
+	logger = logger.With("handler", handlerName)
 
 	return func(c *fiber.Ctx) error {
+		logger := withRequestID(c, logger)
 		logger.Debug(handlerLogMsg)
 
-		requestedType := c.Params("type")
-		requestedID := c.Params("id")
+		// Fiber's strings point into buffers that are reused for other requests, but after a timeout the handler keeps running
+		// and the fallback cache keeps its key, so these must be copies.
+		// The ID must be copied before unescaping it, because unescaping returns the same string when there's nothing to unescape.
+		requestedType := utils.CopyString(c.Params("type"))
+		requestedID := utils.CopyString(c.Params("id"))
 		requestedID, err := url.PathUnescape(requestedID)
 		if err != nil {
-			logger.Error("Requested ID couldn't be unescaped", zap.String("requestedID", requestedID))
+			logger.Error("Requested ID couldn't be unescaped", "requestedID", requestedID)
 			return c.SendStatus(fiber.StatusBadRequest)
 		}
 
-		zapLogType, zapLogID := zap.String("requestedType", requestedType), zap.String("requestedID", requestedID)
+		logger = logger.With("requestedType", requestedType, "requestedID", requestedID)
 
-		// Check if we have a handler for the type
-		handler, ok := handlers[requestedType]
+		// Check if we have a handler for the type and ID
+		handler, ok := findHandler(requestedType, requestedID)
 		if !ok {
-			logger.Warn("Got request for unhandled type; returning 404")
+			logger.Warn("Got request for unhandled type or ID; returning 404")
 			return c.SendStatus(fiber.StatusNotFound)
 		}
 
+		// Parsed ID for stream and meta handlers. Handlers can still deal with unparsable IDs themselves.
+		if resource != "catalog" {
+			if videoID, err := videoid.Parse(requestedID); err == nil {
+				c.Locals("videoID", videoID)
+			}
+		}
+
+		// Catalog extra, like "search=foo" or "genre=Action&skip=100"
+		if extraString := utils.CopyString(c.Params("extra")); extraString != "" {
+			extra, err := parseCatalogExtra(extraString)
+			if err != nil {
+				logger.Warn("Couldn't parse catalog extra; returning 400", "error", err)
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+			c.Locals("catalogExtra", extra)
+		}
+
 		// Decode user data
 		var userData interface{}
-		userDataString := c.Params("userData")
+		userDataString := utils.CopyString(c.Params("userData"))
 		if userDataType == nil {
 			userData = userDataString
 		} else if userDataString == "" {
 			userData = nil
 		} else {
+			_, span := startSpan(c, "decode user data")
 			var err error
-			if userData, err = decodeUserData(userDataString, userDataType, logger, userDataIsBase64); err != nil {
+			userData, err = decodeUserData(userDataString, userDataType, logger, userDataIsBase64)
+			endSpan(span, err)
+			if err != nil {
 				return c.SendStatus(fiber.StatusBadRequest)
 			}
 		}
 
-		res, err := handler(c.Context(), requestedID, userData)
+		ctx, cancel := newHandlerContext(c, baseCtx, timeout)
+		defer cancel()
+		// The handler's span goes into the handler context, so that handlers can create child spans
+		_, span := startSpan(c, handlerName, attribute.String("stremio.type", requestedType), attribute.String("stremio.id", requestedID))
+		ctx = trace.ContextWithSpan(ctx, span)
+		handlerStart := time.Now()
+		res, err := callHandler(ctx, handler, requestedID, userData)
+		c.Locals("handlerDuration", time.Since(handlerStart))
+		if err == NotFound {
+			// Not a failure, just a regular response
+			endSpan(span, nil)
+		} else {
+			endSpan(span, err)
+		}
+		if err != nil && ctx.Err() != nil {
+			if ctx.Err() == context.DeadlineExceeded {
+				logger.Warn("Handler timed out", "timeout", timeout)
+				switch {
+				case timeoutFallback == TimeoutFallbackEmpty && resource != "meta":
+					res, err = []struct{}{}, nil
+				case timeoutFallback == TimeoutFallbackCache:
+					if resBody, ok := fallbackResponses.get(c.Path()); ok {
+						logger.Debug("Responding with last successful response")
+						c.Locals("cacheStatus", "STALE")
+						c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
+						return c.Send(resBody)
+					}
+					return c.SendStatus(fiber.StatusGatewayTimeout)
+				default:
+					return c.SendStatus(fiber.StatusGatewayTimeout)
+				}
+			} else {
+				// The client disconnected or the server is shutting down
+				logger.Debug("Handler was cancelled", "error", err)
+				return c.SendStatus(fiber.StatusServiceUnavailable)
+			}
+		}
 		if err != nil {
 			switch err {
 			case NotFound:
@@ -207,14 +276,14 @@ func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey
 				logger.Warn("Got bad request; returning 400")
 				return c.SendStatus(fiber.StatusBadRequest)
 			default:
-				logger.Error("Addon returned error", zap.Error(err), zapLogType, zapLogID)
+				logger.Error("Addon returned error", "error", err)
 				return c.SendStatus(fiber.StatusInternalServerError)
 			}
 		}
 
 		resBody, err := json.Marshal(res)
 		if err != nil {
-			logger.Error("Couldn't marshal response", zap.Error(err), zapLogType, zapLogID)
+			logger.Error("Couldn't marshal response", "error", err)
 			return c.SendStatus(fiber.StatusInternalServerError)
 		}
 
@@ -224,17 +293,17 @@ func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey
 			hash := xxhash.Sum64(resBody)
 			eTag = strconv.FormatUint(hash, 16)
 			ifNoneMatch := c.Get("If-None-Match")
-			zapLogIfNoneMatch, zapLogETagServer := zap.String("If-None-Match", ifNoneMatch), zap.String("ETag", eTag)
 			modified := false
 			if ifNoneMatch == "*" {
-				logger.Debug("If-None-Match is \"*\", responding with 304", zapLogIfNoneMatch, zapLogETagServer, zapLogType, zapLogID)
+				logger.Debug("If-None-Match is \"*\", responding with 304", "If-None-Match", ifNoneMatch, "ETag", eTag)
 			} else if ifNoneMatch != eTag {
-				logger.Debug("If-None-Match != ETag", zapLogIfNoneMatch, zapLogETagServer, zapLogType, zapLogID)
+				logger.Debug("If-None-Match != ETag", "If-None-Match", ifNoneMatch, "ETag", eTag)
 				modified = true
 			} else {
-				logger.Debug("ETag matches, responding with 304", zapLogIfNoneMatch, zapLogETagServer, zapLogType, zapLogID)
+				logger.Debug("ETag matches, responding with 304", "If-None-Match", ifNoneMatch, "ETag", eTag)
 			}
 			if !modified {
+				c.Locals("cacheStatus", "HIT")
 				c.Set(fiber.HeaderCacheControl, cacheHeaderVal) // Required according to https://tools.ietf.org/html/rfc7232#section-4.1
 				c.Set(fiber.HeaderETag, eTag)                   // We set it to make sure a client doesn't overwrite its cached ETag with an empty string or so.
 				return c.SendStatus(fiber.StatusNotModified)
@@ -248,7 +317,15 @@ func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey
 			resBody = append(resBody, '}')
 		}
 
-		logger.Debug("Responding", zap.ByteString("body", resBody), zapLogType, zapLogID)
+		if fallbackResponses != nil {
+			// The key outlives the request, so it must be a copy. Looking it up with the original string is fine.
+			fallbackResponses.set(utils.CopyString(c.Path()), resBody)
+		}
+		if handleEtag {
+			c.Locals("cacheStatus", "MISS")
+		}
+
+		logger.Debug("Responding", "body", byteString(resBody))
 		c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSON)
 		if cacheHeaderVal != "" {
 			c.Set(fiber.HeaderCacheControl, cacheHeaderVal)
@@ -260,18 +337,19 @@ func createHandler(handlerName string, handlers map[string]handler, jsonArrayKey
 	}
 }
 
-func createRootHandler(redirectURL string, logger *zap.Logger) fiber.Handler {
+func createRootHandler(redirectURL string, logger logging.Logger) fiber.Handler {
 	return func(c *fiber.Ctx) error {
 		logger.Debug("rootHandler called")
 
-		logger.Debug("Responding with redirect", zap.String("redirectURL", redirectURL))
+		logger.Debug("Responding with redirect", "redirectURL", redirectURL)
 		c.Set(fiber.HeaderLocation, redirectURL)
 		return c.SendStatus(fiber.StatusMovedPermanently)
 	}
 }
 
-func decodeUserData(data string, t reflect.Type, logger *zap.Logger, userDataIsBase64 bool) (interface{}, error) {
-	logger.Debug("Decoding user data", zap.String("userData", data))
+func decodeUserData(data string, t reflect.Type, logger logging.Logger, userDataIsBase64 bool) (interface{}, error) {
+	// The user data can contain secrets like API tokens, so we only log a fingerprint
+	logger.Debug("Decoding user data", "userData", userDataFingerprint(data))
 
 	var userDataDecoded []byte
 	var err error
@@ -286,15 +364,15 @@ func decodeUserData(data string, t reflect.Type, logger *zap.Logger, userDataIsB
 	}
 	if err != nil {
 		// We use WARN instead of ERROR because it's most likely an *encoding* error on the client side
-		logger.Warn("Couldn't decode user data", zap.Error(err))
+		logger.Warn("Couldn't decode user data", "error", err)
 		return nil, err
 	}
 
 	userData := reflect.New(t).Interface()
 	if err := json.Unmarshal(userDataDecoded, userData); err != nil {
-		logger.Warn("Couldn't unmarshal user data", zap.Error(err))
+		logger.Warn("Couldn't unmarshal user data", "error", err)
 		return nil, err
 	}
-	logger.Debug("Decoded user data", zap.String("userData", fmt.Sprintf("%+v", userData)))
+	logger.Debug("Decoded user data", "userData", redactUserData(userData))
 	return userData, nil
 }
@@ -0,0 +1,68 @@
+package stremio
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestHandlerTimeout(t *testing.T) {
+	var slow atomic.Bool
+	handlerErrs := make(chan error, 10)
+	streamHandler := convertStreamHandler(func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		if slow.Load() {
+			<-ctx.Done()
+			handlerErrs <- ctx.Err()
+			return nil, ctx.Err()
+		}
+		return []StreamItem{{URL: "https://example.com/" + id + ".mp4"}}, nil
+	})
+	findHandler := func(t, id string) (handler, bool) { return streamHandler, true }
+
+	tests := []struct {
+		name     string
+		fallback TimeoutFallback
+		// Response bodies for a timed out request for a previously successful ID and for a new ID, or "" for a 504
+		wantPrevious string
+		wantNew      string
+	}{
+		{"status", TimeoutFallbackStatus, "", ""},
+		{"empty", TimeoutFallbackEmpty, `{"streams":[]}`, `{"streams":[]}`},
+		{"cache", TimeoutFallbackCache, `{"streams":[{"url":"https://example.com/tt1.mp4"}]}`, ""},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			slow.Store(false)
+			app := fiber.New()
+			app.Get("/stream/:type/:id.json", createStreamHandler(findHandler, 0, false, false, 20*time.Millisecond, tc.fallback, context.Background(), logging.NewNopLogger(), nil, false))
+
+			res, err := app.Test(httptest.NewRequest("GET", "/stream/movie/tt1.json", nil))
+			require.NoError(t, err)
+			require.Equal(t, fiber.StatusOK, res.StatusCode)
+
+			slow.Store(true)
+			for path, want := range map[string]string{"/stream/movie/tt1.json": tc.wantPrevious, "/stream/movie/tt2.json": tc.wantNew} {
+				res, err = app.Test(httptest.NewRequest("GET", path, nil))
+				require.NoError(t, err)
+				// The handler's context is cancelled when the timeout is reached
+				require.ErrorIs(t, <-handlerErrs, context.DeadlineExceeded)
+				if want == "" {
+					require.Equal(t, fiber.StatusGatewayTimeout, res.StatusCode, path)
+					continue
+				}
+				require.Equal(t, fiber.StatusOK, res.StatusCode, path)
+				body, err := io.ReadAll(res.Body)
+				require.NoError(t, err)
+				require.Equal(t, want, string(body), path)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package stremio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+// HealthCheck checks whether a dependency of the addon, like a database, is available.
+// It should return an error if it isn't.
+// The context is cancelled when the check's timeout is reached.
+type HealthCheck func(ctx context.Context) error
+
+// HealthCheckOptions are the options for a health check.
+type HealthCheckOptions struct {
+	// Maximum duration of the check. When the check takes longer, it's considered failed.
+	// Default 5 seconds.
+	Timeout time.Duration
+	// Duration for which the result of the check is reused, so that frequent readiness probes don't overload the dependency.
+	// Default 0 (no caching).
+	CacheDuration time.Duration
+}
+
+// DefaultHealthCheckOptions is a HealthCheckOptions object with default values.
+// For fields that aren't set here the zero value is the default value.
+var DefaultHealthCheckOptions = HealthCheckOptions{
+	Timeout: 5 * time.Second,
+}
+
+// healthCheckResult is the result of a single check in the readiness endpoint's response.
+type healthCheckResult struct {
+	// "ok" or "failing"
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+	// In seconds
+	Duration float64 `json:"duration"`
+	// When the check ran. Differs from the time of the request when the result was cached.
+	Time time.Time `json:"time"`
+}
+
+// healthResponse is the response body of the liveness and readiness endpoints.
+type healthResponse struct {
+	// "ok", "failing" or "shutting down"
+	Status string                       `json:"status"`
+	Checks map[string]healthCheckResult `json:"checks,omitempty"`
+}
+
+type healthCheck struct {
+	name  string
+	check HealthCheck
+	opts  HealthCheckOptions
+
+	// Held while the check runs, so concurrent readiness probes wait for the same result instead of running the check again when caching is enabled
+	lock       *sync.Mutex
+	lastResult healthCheckResult
+}
+
+func newHealthCheck(name string, check HealthCheck, opts HealthCheckOptions) *healthCheck {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultHealthCheckOptions.Timeout
+	}
+	return &healthCheck{
+		name:  name,
+		check: check,
+		opts:  opts,
+		lock:  &sync.Mutex{},
+	}
+}
+
+// run runs the check or returns the cached result.
+func (hc *healthCheck) run() healthCheckResult {
+	hc.lock.Lock()
+	defer hc.lock.Unlock()
+	if hc.opts.CacheDuration > 0 && !hc.lastResult.Time.IsZero() && time.Since(hc.lastResult.Time) < hc.opts.CacheDuration {
+		return hc.lastResult
+	}
+
+	// Not derived from the request context, because the check might still run after the response was sent, when it doesn't respect the context
+	ctx, cancel := context.WithTimeout(context.Background(), hc.opts.Timeout)
+	defer cancel()
+	start := time.Now()
+	errChan := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				errChan <- fmt.Errorf("Health check panicked: %v", r)
+			}
+		}()
+		errChan <- hc.check(ctx)
+	}()
+	var err error
+	select {
+	case err = <-errChan:
+	case <-ctx.Done():
+		err = errors.New("Health check timed out")
+	}
+
+	result := healthCheckResult{
+		Status:   "ok",
+		Duration: time.Since(start).Seconds(),
+		Time:     start,
+	}
+	if err != nil {
+		result.Status = "failing"
+		result.Error = err.Error()
+	}
+	hc.lastResult = result
+	return result
+}
+
+// healthChecker runs the registered health checks for the readiness endpoint.
+type healthChecker struct {
+	checks       []*healthCheck
+	shuttingDown *atomic.Bool
+}
+
+func newHealthChecker(checks []*healthCheck) *healthChecker {
+	return &healthChecker{
+		checks:       checks,
+		shuttingDown: &atomic.Bool{},
+	}
+}
+
+// setShuttingDown makes the readiness endpoint respond with "503 Service Unavailable", so that load balancers stop sending requests.
+func (h *healthChecker) setShuttingDown() {
+	h.shuttingDown.Store(true)
+}
+
+// createLivenessHandler creates a handler that responds with "200 OK" as long as the server is running.
+// It deliberately doesn't run any checks, because an unavailable dependency isn't a reason to restart the addon.
+func createLivenessHandler(logger logging.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		logger.Debug("livenessHandler called")
+		return c.JSON(healthResponse{Status: "ok"})
+	}
+}
+
+// createReadinessHandler creates a handler that runs all health checks concurrently and responds with their results.
+// The status is "503 Service Unavailable" when a check fails or the server is shutting down.
+func createReadinessHandler(h *healthChecker, logger logging.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		logger := withRequestID(c, logger)
+		logger.Debug("readinessHandler called")
+
+		if h.shuttingDown.Load() {
+			c.Status(fiber.StatusServiceUnavailable)
+			return c.JSON(healthResponse{Status: "shutting down"})
+		}
+
+		res := healthResponse{
+			Status: "ok",
+			Checks: make(map[string]healthCheckResult, len(h.checks)),
+		}
+		lock := &sync.Mutex{}
+		var wg sync.WaitGroup
+		for _, check := range h.checks {
+			wg.Add(1)
+			go func(check *healthCheck) {
+				defer wg.Done()
+				result := check.run()
+				lock.Lock()
+				defer lock.Unlock()
+				res.Checks[check.name] = result
+				if result.Status != "ok" {
+					res.Status = "failing"
+				}
+			}(check)
+		}
+		wg.Wait()
+
+		if res.Status != "ok" {
+			logger.Warn("Readiness check failed", "checks", fmt.Sprintf("%+v", res.Checks))
+			c.Status(fiber.StatusServiceUnavailable)
+		}
+		return c.JSON(res)
+	}
+}
@@ -0,0 +1,67 @@
+package stremio
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestHealthCheckCaching(t *testing.T) {
+	calls := 0
+	hc := newHealthCheck("foo", func(ctx context.Context) error {
+		calls++
+		return nil
+	}, HealthCheckOptions{CacheDuration: time.Hour})
+
+	first := hc.run()
+	second := hc.run()
+	require.Equal(t, "ok", first.Status)
+	require.Equal(t, first, second)
+	require.Equal(t, 1, calls)
+}
+
+func TestHealthCheckTimeout(t *testing.T) {
+	hc := newHealthCheck("foo", func(ctx context.Context) error {
+		// Ignores the context on purpose
+		time.Sleep(time.Second)
+		return nil
+	}, HealthCheckOptions{Timeout: 10 * time.Millisecond})
+
+	result := hc.run()
+	require.Equal(t, "failing", result.Status)
+	require.Contains(t, result.Error, "timed out")
+}
+
+func TestReadinessHandler(t *testing.T) {
+	health := newHealthChecker([]*healthCheck{
+		newHealthCheck("ok", func(ctx context.Context) error { return nil }, HealthCheckOptions{}),
+		newHealthCheck("db", func(ctx context.Context) error { return errors.New("connection refused") }, HealthCheckOptions{}),
+	})
+	app := fiber.New()
+	app.Get("/health/ready", createReadinessHandler(health, logging.NewNopLogger()))
+
+	res, err := app.Test(httptest.NewRequest("GET", "/health/ready", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusServiceUnavailable, res.StatusCode)
+	var body healthResponse
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	require.Equal(t, "failing", body.Status)
+	require.Equal(t, "ok", body.Checks["ok"].Status)
+	require.Equal(t, "connection refused", body.Checks["db"].Error)
+
+	health.setShuttingDown()
+	res, err = app.Test(httptest.NewRequest("GET", "/health/ready", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusServiceUnavailable, res.StatusCode)
+	body = healthResponse{}
+	require.NoError(t, json.NewDecoder(res.Body).Decode(&body))
+	require.Equal(t, "shutting down", body.Status)
+}
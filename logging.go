@@ -3,12 +3,15 @@ package stremio
 import (
 	"errors"
 	"fmt"
+	"os"
 
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
 )
 
-// NewLogger creates a new logger with sane defaults and the passed level.
+// NewLogger creates a new zap logger with sane defaults and the passed level.
 // Supported levels are: debug, info, warn, error.
 // Only logs with that level and above are then logged (e.g. with "info" no debug logs will be logged).
 // The encoding parameter is optional and will only be used when non-zero. Valid values: "console" (default) and "json".
@@ -17,9 +20,9 @@ import (
 // ManifestCallback, CatalogHandler and StreamHandler,
 // so that all logs behave and are formatted the same way.
 // You should then also set this logger in the options for `NewAddon()`,
-// so that not two loggers are created.
-// Alternatively you can create your own custom *zap.Logger and set it in the options
-// when creating a new addon, leading to the addon using that custom logger.
+// so that not two loggers are created. Wrap it with `logging.NewZapLogger()` for that.
+// Alternatively you can set any other logger in the options, for example a log/slog logger wrapped with `logging.NewSlogLogger()`,
+// leading to the addon using that custom logger.
 func NewLogger(level, encoding string) (*zap.Logger, error) {
 	logLevel, err := parseZapLevel(level)
 	if err != nil {
@@ -73,3 +76,25 @@ func parseZapLevel(logLevel string) (zapcore.Level, error) {
 	}
 	return 0, errors.New(`unknown log level - only knows ["debug", "info", "warn", "error"]`)
 }
+
+// fatal logs the message with error level, flushes the logger if it's buffered and then exits, like zap's Fatal.
+func fatal(logger logging.Logger, msg string, keysAndValues ...interface{}) {
+	logger.Error(msg, keysAndValues...)
+	if syncer, ok := logger.(logging.Syncer); ok {
+		_ = syncer.Sync()
+	}
+	os.Exit(1)
+}
+
+// byteString makes byte slices (like response bodies) show up as string in logs.
+// Contrary to converting them to a string it doesn't copy the bytes unless the log entry is actually written.
+type byteString []byte
+
+func (b byteString) String() string {
+	return string(b)
+}
+
+// MarshalText implements encoding.TextMarshaler, which is used by for example slog's JSON handler.
+func (b byteString) MarshalText() ([]byte, error) {
+	return b, nil
+}
@@ -0,0 +1,383 @@
+package stremio
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// supportedResources are the resources that go-stremio has handlers for.
+var supportedResources = []string{"catalog", "stream", "meta"}
+
+// ValidateManifest checks the manifest for inconsistencies with itself and with the handlers.
+// In contrast to the checks in NewAddon, it returns *all* problems it finds, joined with errors.Join, so they can be fixed at once.
+// It checks for example that each advertised resource and type has a handler, that each handler is advertised,
+// and that each catalog has a catalog handler for its type.
+// Resource items without types are treated like Stremio does, as supporting all of the manifest's types.
+// It returns nil if the manifest is consistent.
+func ValidateManifest(manifest Manifest, catalogHandlers map[string]CatalogHandler, streamHandlers map[string]StreamHandler, metaHandlers map[string]MetaHandler) error {
+	return validateManifest(manifest, handlerSet{
+		catalog: catalogHandlers,
+		stream:  streamHandlers,
+		meta:    metaHandlers,
+	})
+}
+
+func validateManifest(manifest Manifest, handlers handlerSet) error {
+	var errs []error
+	addErr := func(format string, a ...interface{}) {
+		errs = append(errs, fmt.Errorf(format, a...))
+	}
+
+	// Required fields
+	for field, value := range map[string]string{"ID": manifest.ID, "Name": manifest.Name, "Description": manifest.Description, "Version": manifest.Version} {
+		if value == "" {
+			addErr("Manifest field %v is empty", field)
+		}
+	}
+	if manifest.Catalogs == nil {
+		addErr("Manifest catalogs are nil, which Stremio doesn't accept. Use an empty slice instead")
+	}
+	if len(manifest.ResourceItems) == 0 {
+		addErr("Manifest doesn't declare any resources")
+	}
+
+	declaredTypes := map[string]bool{}
+	for _, t := range manifest.Types {
+		if declaredTypes[t] {
+			addErr("Type %q is declared multiple times", t)
+		}
+		declaredTypes[t] = true
+	}
+
+	handledTypes := map[string]map[string]bool{
+		"catalog": {},
+		"stream":  {},
+		"meta":    {},
+	}
+	for t := range handlers.catalog {
+		handledTypes["catalog"][t] = true
+	}
+	for t := range handlers.catalogByID {
+		handledTypes["catalog"][t] = true
+	}
+	for t := range handlers.stream {
+		handledTypes["stream"][t] = true
+	}
+	for t := range handlers.streamByPrefix {
+		handledTypes["stream"][t] = true
+	}
+	for t := range handlers.meta {
+		handledTypes["meta"][t] = true
+	}
+	for t := range handlers.metaByPrefix {
+		handledTypes["meta"][t] = true
+	}
+
+	// Each advertised resource and type must have a handler
+	advertisedTypes := map[string]map[string]bool{}
+	advertisedIDprefixes := map[string][]string{}
+	usedTypes := map[string]bool{}
+	for _, item := range manifest.ResourceItems {
+		if !containsString(supportedResources, item.Name) {
+			addErr("Resource %q isn't supported", item.Name)
+			continue
+		} else if advertisedTypes[item.Name] != nil {
+			addErr("Resource %q is declared multiple times", item.Name)
+			continue
+		}
+		types := item.Types
+		if len(types) == 0 {
+			types = manifest.Types
+		}
+		advertisedIDprefixes[item.Name] = item.IDprefixes
+		if len(item.IDprefixes) == 0 {
+			advertisedIDprefixes[item.Name] = manifest.IDprefixes
+		}
+		advertisedTypes[item.Name] = map[string]bool{}
+		for _, t := range types {
+			advertisedTypes[item.Name][t] = true
+			usedTypes[t] = true
+			if !declaredTypes[t] {
+				addErr("Resource %q declares type %q, which isn't in the manifest's types", item.Name, t)
+			}
+			if !handledTypes[item.Name][t] {
+				addErr("Resource %q declares type %q, but there's no %v handler for it", item.Name, t, item.Name)
+			}
+		}
+	}
+	for t := range declaredTypes {
+		if !usedTypes[t] {
+			addErr("Type %q isn't used by any resource", t)
+		}
+	}
+
+	// Each handler must be advertised
+	for _, resource := range supportedResources {
+		for t := range handledTypes[resource] {
+			if !advertisedTypes[resource][t] {
+				addErr("There's a %v handler for type %q, but the manifest doesn't declare it", resource, t)
+			}
+		}
+	}
+
+	// Without advertised ID prefixes Stremio sends requests for all IDs, but with them, only for the advertised ones
+	for resource, byPrefix := range map[string]map[string]bool{"stream": prefixes(handlers.streamByPrefix), "meta": prefixes(handlers.metaByPrefix)} {
+		for idPrefix := range byPrefix {
+			if len(advertisedIDprefixes[resource]) > 0 && !containsString(advertisedIDprefixes[resource], idPrefix) {
+				addErr("There's a %v handler for ID prefix %q, but the manifest doesn't declare it", resource, idPrefix)
+			}
+		}
+	}
+
+	// Catalogs must match the catalog handlers
+	catalogTypes := map[string]bool{}
+	catalogIDs := map[string]bool{}
+	for _, catalog := range manifest.Catalogs {
+		if catalog.Type == "" || catalog.ID == "" || catalog.Name == "" {
+			addErr("Catalog %q of type %q must have a type, ID and name", catalog.ID, catalog.Type)
+			continue
+		}
+		catalogTypes[catalog.Type] = true
+		if catalogIDs[catalog.Type+"/"+catalog.ID] {
+			addErr("Catalog %q of type %q is declared multiple times", catalog.ID, catalog.Type)
+		}
+		catalogIDs[catalog.Type+"/"+catalog.ID] = true
+		if !advertisedTypes["catalog"][catalog.Type] {
+			addErr("Catalog %q has type %q, but the catalog resource doesn't declare it", catalog.ID, catalog.Type)
+		}
+		_, hasIDHandler := handlers.catalogByID[catalog.Type][catalog.ID]
+		if _, hasTypeHandler := handlers.catalog[catalog.Type]; !hasTypeHandler && !hasIDHandler {
+			addErr("Catalog %q has type %q, but there's no catalog handler for it", catalog.ID, catalog.Type)
+		}
+	}
+	for t := range handlers.catalog {
+		if !catalogTypes[t] {
+			addErr("There's a catalog handler for type %q, but no catalog of that type", t)
+		}
+	}
+	for t, byID := range handlers.catalogByID {
+		for id := range byID {
+			if !catalogIDs[t+"/"+id] {
+				addErr("There's a catalog handler for catalog %q of type %q, but the manifest doesn't declare it", id, t)
+			}
+		}
+	}
+
+	// Sorting makes the result deterministic, as most errors come from iterating over maps
+	sort.Slice(errs, func(i, j int) bool {
+		return errs[i].Error() < errs[j].Error()
+	})
+	return errors.Join(errs...)
+}
+
+// prefixes returns the set of ID prefixes of handlers by type and prefix.
+func prefixes[H any](handlers map[string]map[string]H) map[string]bool {
+	result := map[string]bool{}
+	for _, byPrefix := range handlers {
+		for idPrefix := range byPrefix {
+			result[idPrefix] = true
+		}
+	}
+	return result
+}
+
+// ManifestBuilder builds a manifest and the matching handler maps.
+// The manifest's resources, types and catalogs are derived from the registered handlers, so they can't get out of sync.
+// The methods can be chained, for example:
+//
+//	addon, err := stremio.NewManifestBuilder("com.example.foo", "Foo", "Foo addon", "0.1.0").
+//		StreamHandler("movie", movieStreamHandler, "tt").
+//		CatalogHandler("movie", movieCatalogHandler).
+//		Catalog(stremio.CatalogItem{Type: "movie", ID: "popular", Name: "Popular movies"}).
+//		NewAddon(stremio.Options{})
+type ManifestBuilder struct {
+	manifest Manifest
+	handlers handlerSet
+	// By resource
+	idPrefixes map[string][]string
+}
+
+// NewManifestBuilder creates a new ManifestBuilder with the required fields of a manifest.
+func NewManifestBuilder(id, name, description, version string) *ManifestBuilder {
+	return &ManifestBuilder{
+		manifest: Manifest{
+			ID:          id,
+			Name:        name,
+			Description: description,
+			Version:     version,
+			Catalogs:    []CatalogItem{},
+		},
+		handlers: handlerSet{
+			catalog:        map[string]CatalogHandler{},
+			catalogByID:    map[string]map[string]CatalogHandler{},
+			stream:         map[string]StreamHandler{},
+			streamByPrefix: map[string]map[string]StreamHandler{},
+			meta:           map[string]MetaHandler{},
+			metaByPrefix:   map[string]map[string]MetaHandler{},
+		},
+		idPrefixes: map[string][]string{},
+	}
+}
+
+// Background sets the URL of the manifest's background image.
+func (b *ManifestBuilder) Background(url string) *ManifestBuilder {
+	b.manifest.Background = url
+	return b
+}
+
+// Logo sets the URL of the manifest's logo.
+func (b *ManifestBuilder) Logo(url string) *ManifestBuilder {
+	b.manifest.Logo = url
+	return b
+}
+
+// ContactEmail sets the manifest's contact email.
+func (b *ManifestBuilder) ContactEmail(email string) *ManifestBuilder {
+	b.manifest.ContactEmail = email
+	return b
+}
+
+// BehaviorHints sets the manifest's behavior hints.
+func (b *ManifestBuilder) BehaviorHints(behaviorHints BehaviorHints) *ManifestBuilder {
+	b.manifest.BehaviorHints = behaviorHints
+	return b
+}
+
+// CatalogHandler registers the catalog handler for a type.
+// Declare the catalogs of the type with Catalog().
+func (b *ManifestBuilder) CatalogHandler(t string, catalogHandler CatalogHandler) *ManifestBuilder {
+	b.handlers.catalog[t] = catalogHandler
+	return b
+}
+
+// CatalogItemHandler declares a catalog and registers a handler for only this catalog.
+// It takes precedence over a handler for the catalog's type.
+func (b *ManifestBuilder) CatalogItemHandler(catalog CatalogItem, catalogHandler CatalogHandler) *ManifestBuilder {
+	if b.handlers.catalogByID[catalog.Type] == nil {
+		b.handlers.catalogByID[catalog.Type] = map[string]CatalogHandler{}
+	}
+	b.handlers.catalogByID[catalog.Type][catalog.ID] = catalogHandler
+	return b.Catalog(catalog)
+}
+
+// Catalog declares a catalog. There must be a catalog handler for its type.
+func (b *ManifestBuilder) Catalog(catalog CatalogItem) *ManifestBuilder {
+	b.manifest.Catalogs = append(b.manifest.Catalogs, catalog.clone())
+	return b
+}
+
+// StreamHandler registers the stream handler for a type.
+// The optional ID prefixes (like "tt" for IMDb IDs) are added to the stream resource of the manifest.
+func (b *ManifestBuilder) StreamHandler(t string, streamHandler StreamHandler, idPrefixes ...string) *ManifestBuilder {
+	b.handlers.stream[t] = streamHandler
+	b.addIDprefixes("stream", idPrefixes)
+	return b
+}
+
+// MetaHandler registers the meta handler for a type.
+// The optional ID prefixes (like "tt" for IMDb IDs) are added to the meta resource of the manifest.
+func (b *ManifestBuilder) MetaHandler(t string, metaHandler MetaHandler, idPrefixes ...string) *ManifestBuilder {
+	b.handlers.meta[t] = metaHandler
+	b.addIDprefixes("meta", idPrefixes)
+	return b
+}
+
+// StreamPrefixHandler registers the stream handler for IDs with the given prefix (like "kitsu:") of a type.
+// It takes precedence over the handler for the type, and the longest matching prefix wins.
+// The prefix is added to the stream resource of the manifest.
+func (b *ManifestBuilder) StreamPrefixHandler(t, idPrefix string, streamHandler StreamHandler) *ManifestBuilder {
+	if b.handlers.streamByPrefix[t] == nil {
+		b.handlers.streamByPrefix[t] = map[string]StreamHandler{}
+	}
+	b.handlers.streamByPrefix[t][idPrefix] = streamHandler
+	b.addIDprefixes("stream", []string{idPrefix})
+	return b
+}
+
+// MetaPrefixHandler registers the meta handler for IDs with the given prefix (like "kitsu:") of a type.
+// It takes precedence over the handler for the type, and the longest matching prefix wins.
+// The prefix is added to the meta resource of the manifest.
+func (b *ManifestBuilder) MetaPrefixHandler(t, idPrefix string, metaHandler MetaHandler) *ManifestBuilder {
+	if b.handlers.metaByPrefix[t] == nil {
+		b.handlers.metaByPrefix[t] = map[string]MetaHandler{}
+	}
+	b.handlers.metaByPrefix[t][idPrefix] = metaHandler
+	b.addIDprefixes("meta", []string{idPrefix})
+	return b
+}
+
+func (b *ManifestBuilder) addIDprefixes(resource string, idPrefixes []string) {
+	for _, idPrefix := range idPrefixes {
+		if !containsString(b.idPrefixes[resource], idPrefix) {
+			b.idPrefixes[resource] = append(b.idPrefixes[resource], idPrefix)
+		}
+	}
+}
+
+// Build returns the manifest, with the resources and types derived from the registered handlers.
+// It returns all inconsistencies that ValidateManifest finds.
+func (b *ManifestBuilder) Build() (Manifest, error) {
+	manifest := b.manifest.clone()
+	manifest.ResourceItems = nil
+	manifest.Types = nil
+
+	allTypes := map[string]bool{}
+	addResource := func(name string, types []string) {
+		if len(types) == 0 {
+			return
+		}
+		sort.Strings(types)
+		manifest.ResourceItems = append(manifest.ResourceItems, ResourceItem{
+			Name:       name,
+			Types:      types,
+			IDprefixes: b.idPrefixes[name],
+		})
+		for _, t := range types {
+			allTypes[t] = true
+		}
+	}
+	addResource("catalog", unionKeys(b.handlers.catalog, b.handlers.catalogByID))
+	addResource("stream", unionKeys(b.handlers.stream, b.handlers.streamByPrefix))
+	addResource("meta", unionKeys(b.handlers.meta, b.handlers.metaByPrefix))
+	manifest.Types = mapKeys(allTypes)
+	sort.Strings(manifest.Types)
+
+	// The IDs prefixes of the manifest itself apply to all resources that don't have their own
+	if len(manifest.ResourceItems) == 1 && len(manifest.ResourceItems[0].IDprefixes) > 0 {
+		manifest.IDprefixes = manifest.ResourceItems[0].IDprefixes
+	}
+
+	if err := validateManifest(manifest, b.handlers); err != nil {
+		return Manifest{}, err
+	}
+	return manifest, nil
+}
+
+// NewAddon builds the manifest and creates a new addon with it and the registered handlers.
+func (b *ManifestBuilder) NewAddon(opts Options) (*Addon, error) {
+	manifest, err := b.Build()
+	if err != nil {
+		return nil, err
+	}
+	return newAddon(manifest, b.handlers, opts)
+}
+
+// unionKeys returns the types of the handlers for whole types and of the handlers by type and another key.
+func unionKeys[H any](typeHandlers map[string]H, nestedHandlers map[string]map[string]H) []string {
+	keys := mapKeys(typeHandlers)
+	for t := range nestedHandlers {
+		if _, ok := typeHandlers[t]; !ok {
+			keys = append(keys, t)
+		}
+	}
+	return keys
+}
+
+func mapKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys
+}
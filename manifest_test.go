@@ -0,0 +1,65 @@
+package stremio
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestManifestBuilder(t *testing.T) {
+	catalogHandler := func(ctx context.Context, id string, userData interface{}) ([]MetaPreviewItem, error) {
+		return nil, nil
+	}
+	streamHandler := func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		return nil, nil
+	}
+
+	manifest, err := NewManifestBuilder("foo", "Foo", "Foo addon", "0.1.0").
+		StreamHandler("series", streamHandler, "tt").
+		StreamHandler("movie", streamHandler, "tt").
+		CatalogHandler("movie", catalogHandler).
+		Catalog(CatalogItem{Type: "movie", ID: "popular", Name: "Popular movies"}).
+		Build()
+	require.NoError(t, err)
+	require.Equal(t, []string{"movie", "series"}, manifest.Types)
+	require.Equal(t, []ResourceItem{
+		{Name: "catalog", Types: []string{"movie"}},
+		{Name: "stream", Types: []string{"movie", "series"}, IDprefixes: []string{"tt"}},
+	}, manifest.ResourceItems)
+	require.Len(t, manifest.Catalogs, 1)
+
+	// A catalog handler without catalog and a catalog without handler
+	_, err = NewManifestBuilder("foo", "Foo", "Foo addon", "0.1.0").
+		CatalogHandler("movie", catalogHandler).
+		Catalog(CatalogItem{Type: "series", ID: "popular", Name: "Popular series"}).
+		Build()
+	require.ErrorContains(t, err, `There's a catalog handler for type "movie", but no catalog of that type`)
+	require.ErrorContains(t, err, `Catalog "popular" has type "series", but there's no catalog handler for it`)
+}
+
+func TestValidateManifest(t *testing.T) {
+	streamHandler := func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		return nil, nil
+	}
+	manifest := Manifest{
+		ID:            "foo",
+		Name:          "Foo",
+		Description:   "Foo addon",
+		Version:       "0.1.0",
+		ResourceItems: []ResourceItem{{Name: "stream"}},
+		Types:         []string{"movie"},
+		Catalogs:      []CatalogItem{},
+	}
+	require.NoError(t, ValidateManifest(manifest, nil, map[string]StreamHandler{"movie": streamHandler}, nil))
+
+	// All problems are reported
+	manifest.Version = ""
+	manifest.Types = []string{"movie", "series"}
+	manifest.Catalogs = nil
+	err := ValidateManifest(manifest, nil, map[string]StreamHandler{"movie": streamHandler, "channel": streamHandler}, nil)
+	require.ErrorContains(t, err, "Manifest field Version is empty")
+	require.ErrorContains(t, err, "Manifest catalogs are nil")
+	require.ErrorContains(t, err, `Resource "stream" declares type "series", but there's no stream handler for it`)
+	require.ErrorContains(t, err, `There's a stream handler for type "channel", but the manifest doesn't declare it`)
+}
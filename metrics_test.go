@@ -0,0 +1,107 @@
+package stremio
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+// scrapeMetric returns the value of the time series from the Prometheus exposition of the default metrics set,
+// or -1 if it doesn't exist.
+func scrapeMetric(t *testing.T, series string) float64 {
+	buf := &bytes.Buffer{}
+	metrics.WritePrometheus(buf, false)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			f, err := strconv.ParseFloat(value, 64)
+			require.NoError(t, err)
+			return f
+		}
+	}
+	return -1
+}
+
+func TestEndpointFromRoute(t *testing.T) {
+	tests := []struct {
+		method string
+		path   string
+		want   string
+	}{
+		{"USE", "/", "other"},
+		{"GET", "/", "root"},
+		{"GET", "/:userData/manifest.json", "manifest-data"},
+		{"GET", "/catalog/:type/:id/:extra.json", "catalog"},
+		{"GET", "/:userData/stream/:type/:id.json", "stream-data"},
+		{"GET", "/debug/pprof/heap", "pprof"},
+		{"GET", "/my-custom-endpoint", "other"},
+	}
+	for _, tc := range tests {
+		require.Equal(t, tc.want, endpointFromRoute(&fiber.Route{Method: tc.method, Path: tc.path}), tc.path)
+	}
+}
+
+func TestMetricsMiddleware(t *testing.T) {
+	streamHandler := convertStreamHandler(func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		return []StreamItem{{URL: "https://example.com/foo.mp4"}}, nil
+	})
+	findHandler := func(t, id string) (handler, bool) { return streamHandler, true }
+	app := fiber.New()
+	app.Use(createMetricsMiddleware([]string{"movie"}))
+	app.Use(createConcurrencyLimitMiddleware("stream", ConcurrencyLimit{MaxInFlight: 1}, true, logging.NewNopLogger()))
+	app.Get("/:userData/stream/:type/:id.json", createStreamHandler(findHandler, 0, false, false, 0, 0, context.Background(), logging.NewNopLogger(), nil, false))
+
+	requestsBefore := scrapeMetric(t, `http_requests_total{endpoint="stream-data", status="200"}`)
+	errorsBefore := scrapeMetric(t, `downstream_handlers_errors_total`)
+	for _, path := range []string{"/foo/stream/movie/tt1.json", "/foo/stream/movie/tt2.json", "/foo/stream/unknown/tt1.json", "/nope"} {
+		_, err := app.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+	}
+
+	require.Equal(t, max(requestsBefore, 0)+3, scrapeMetric(t, `http_requests_total{endpoint="stream-data", status="200"}`))
+	// Fiber's 404 for unknown routes is an error
+	require.Equal(t, max(errorsBefore, 0)+1, scrapeMetric(t, `downstream_handlers_errors_total`))
+	// Unknown types don't lead to new time series
+	require.GreaterOrEqual(t, scrapeMetric(t, `http_request_duration_seconds_count{endpoint="stream-data", type="movie"}`), 2.0)
+	require.GreaterOrEqual(t, scrapeMetric(t, `http_request_duration_seconds_count{endpoint="stream-data", type="other"}`), 1.0)
+	require.Equal(t, -1.0, scrapeMetric(t, `http_request_duration_seconds_count{endpoint="stream-data", type="unknown"}`))
+	require.GreaterOrEqual(t, scrapeMetric(t, `handler_duration_seconds_count{endpoint="stream-data", type="movie"}`), 2.0)
+	require.GreaterOrEqual(t, scrapeMetric(t, `http_response_size_bytes_count{endpoint="stream-data"}`), 3.0)
+	require.Equal(t, 0.0, scrapeMetric(t, `handlers_in_flight{resource="stream"}`))
+	require.Equal(t, 0.0, scrapeMetric(t, `http_requests_in_flight`))
+}
+
+func TestMetricsMiddlewareInFlightMultipleAddons(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	apps := make([]*fiber.App, 2)
+	for i := range apps {
+		apps[i] = fiber.New()
+		apps[i].Use(createMetricsMiddleware([]string{"movie"}))
+		apps[i].Get("/", func(c *fiber.Ctx) error {
+			started <- struct{}{}
+			<-release
+			return c.SendStatus(fiber.StatusOK)
+		})
+	}
+
+	// Requests to the second addon count as well, although the first one registered the gauge
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, _ = apps[1].Test(httptest.NewRequest("GET", "/", nil), -1)
+	}()
+	<-started
+	require.Equal(t, 1.0, scrapeMetric(t, `http_requests_in_flight`))
+	close(release)
+	<-done
+	require.Equal(t, 0.0, scrapeMetric(t, `http_requests_in_flight`))
+}
@@ -1,19 +1,24 @@
 package stremio
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
-	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/VictoriaMetrics/metrics"
 	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+	"github.com/deflix-tv/go-stremio/pkg/videoid"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/cors"
-	"go.uber.org/zap"
+	"github.com/gofiber/fiber/v2/utils"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type customMiddleware struct {
@@ -21,15 +26,51 @@ type customMiddleware struct {
 	mw   fiber.Handler
 }
 
-func createLoggingMiddleware(logger *zap.Logger, logIPs, logUserAgent, logMediaName bool, requiresUserData bool) fiber.Handler {
-	// We always log status, duration, method, URL
-	zapFieldCount := 4
+// maxRequestIDlength is the maximum length of request IDs that are accepted from clients.
+// Longer ones are replaced by a generated ID, so that clients can't flood the logs.
+const maxRequestIDlength = 64
+
+func createRequestIDMiddleware(logger logging.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		// Copied because it's used after the request is done, for example by handlers that timed out and by async loggers
+		requestID := utils.CopyString(c.Get(fiber.HeaderXRequestID))
+		if !isValidRequestID(requestID) {
+			requestID = utils.UUIDv4()
+		}
+		c.Set(fiber.HeaderXRequestID, requestID)
+		c.Locals("requestID", requestID)
+		c.Locals("logger", logger.With("requestID", requestID))
+		return c.Next()
+	}
+}
+
+func isValidRequestID(requestID string) bool {
+	if requestID == "" || len(requestID) > maxRequestIDlength {
+		return false
+	}
+	// Only characters that are common in IDs (like UUIDs, ULIDs and trace IDs), to prevent log injection,
+	// for example with quotes or equal signs in logfmt and access logs
+	for i := 0; i < len(requestID); i++ {
+		ch := requestID[i]
+		if !(ch >= 'a' && ch <= 'z' || ch >= 'A' && ch <= 'Z' || ch >= '0' && ch <= '9' || ch == '-' || ch == '_' || ch == '.' || ch == ':') {
+			return false
+		}
+	}
+	return true
+}
+
+func createLoggingMiddleware(logger logging.Logger, logIPs, logUserAgent, logMediaName bool, requiresUserData, redact bool) fiber.Handler {
+	// We always log status, duration, method, URL, request ID
+	fieldCount := 5
 	if logIPs {
 		// IP and Forwarded-For
-		zapFieldCount += 2
+		fieldCount += 2
 	}
 	if logUserAgent {
-		zapFieldCount++
+		fieldCount++
+	}
+	if logMediaName {
+		fieldCount++
 	}
 
 	return func(c *fiber.Ctx) error {
@@ -38,7 +79,7 @@ func createLoggingMiddleware(logger *zap.Logger, logIPs, logUserAgent, logMediaN
 		// First call the other handlers in the chain!
 		if err := c.Next(); err != nil {
 			return err
-			//logger.Error("Received error from next middleware or handler in logging middleware", zap.Error(err))
+			//logger.Error("Received error from next middleware or handler in logging middleware", "error", err)
 		}
 
 		// Then log
@@ -50,121 +91,168 @@ func createLoggingMiddleware(logger *zap.Logger, logIPs, logUserAgent, logMediaN
 		var mediaName string
 		if logMediaName && isStream {
 			if meta, err := cinemeta.GetMetaFromContext(c.Context()); err != nil && err != cinemeta.ErrNoMeta {
-				logger.Error("Couldn't get meta from context", zap.Error(err))
+				logger.Error("Couldn't get meta from context", "error", err)
 			} else if err != cinemeta.ErrNoMeta {
-				mediaName = fmt.Sprintf("%v (%v)", meta.Name, meta.ReleaseInfo)
+				mediaName = mediaNameFromContext(c, meta)
 			}
 		}
 
-		var zapFields []zap.Field
+		// Alternating keys and values
 		// TODO: To increase performance, don't create a new slice for every request. Use sync.Pool.
-		if logMediaName && isStream {
-			zapFields = make([]zap.Field, zapFieldCount+1)
-		} else {
-			zapFields = make([]zap.Field, zapFieldCount)
-		}
+		fields := make([]interface{}, 0, 2*fieldCount)
 
 		duration := time.Since(start).Milliseconds()
 		durationString := strconv.FormatInt(duration, 10) + "ms"
 
-		zapFields[0] = zap.Int("status", c.Response().StatusCode())
-		zapFields[1] = zap.String("duration", durationString)
-		zapFields[2] = zap.String("method", c.Method())
-		zapFields[3] = zap.String("url", c.OriginalURL())
+		url := c.OriginalURL()
+		if redact {
+			url = redactURL(url)
+		}
+		requestID, _ := c.Locals("requestID").(string)
+		fields = append(fields,
+			"status", c.Response().StatusCode(),
+			"duration", durationString,
+			"method", c.Method(),
+			"url", url,
+			"requestID", requestID,
+		)
 		if logIPs {
-			zapFields[4] = zap.String("ip", c.IP())
-			zapFields[5] = zap.Strings("forwardedFor", c.IPs())
+			ip, forwardedFor := c.IP(), c.IPs()
+			if redact {
+				ip = anonymizeIP(ip)
+				for i := range forwardedFor {
+					forwardedFor[i] = anonymizeIP(forwardedFor[i])
+				}
+			}
+			fields = append(fields, "ip", ip, "forwardedFor", forwardedFor)
 		}
 		if logUserAgent {
-			if !logIPs {
-				zapFields[4] = zap.String("userAgent", c.Get(fiber.HeaderUserAgent))
-			} else {
-				zapFields[6] = zap.String("userAgent", c.Get(fiber.HeaderUserAgent))
-			}
+			fields = append(fields, "userAgent", c.Get(fiber.HeaderUserAgent))
 		}
 		if logMediaName && isStream {
 			if mediaName == "" {
 				mediaName = "?"
 			}
-			if !logIPs && !logUserAgent {
-				zapFields[4] = zap.String("mediaName", mediaName)
-			} else if !logIPs && logUserAgent {
-				zapFields[5] = zap.String("mediaName", mediaName)
-			} else if logIPs && !logUserAgent {
-				zapFields[6] = zap.String("mediaName", mediaName)
-			} else {
-				zapFields[7] = zap.String("mediaName", mediaName)
-			}
+			fields = append(fields, "mediaName", mediaName)
 		}
 
-		logger.Info("Handled request", zapFields...)
+		logger.Info("Handled request", fields...)
 		return nil
 	}
 }
 
-func createMetricsMiddleware() fiber.Handler {
+// routeEndpoints maps the paths of the routes that are registered by the addon to the "endpoint" metrics label.
+var routeEndpoints = map[string]string{
+	"/":                                        "root",
+	"/manifest.json":                           "manifest",
+	"/:userData/manifest.json":                 "manifest-data",
+	"/catalog/:type/:id.json":                  "catalog",
+	"/:userData/catalog/:type/:id.json":        "catalog-data",
+	"/catalog/:type/:id/:extra.json":           "catalog",
+	"/:userData/catalog/:type/:id/:extra.json": "catalog-data",
+	"/stream/:type/:id.json":                   "stream",
+	"/:userData/stream/:type/:id.json":         "stream-data",
+	"/meta/:type/:id.json":                     "meta",
+	"/:userData/meta/:type/:id.json":           "meta-data",
+	"/configure":                               "configure",
+	"/:userData/configure":                     "configure-other",
+	"/health":                                  "health",
+	"/health/live":                             "health-live",
+	"/health/ready":                            "health-ready",
+	"/metrics":                                 "metrics",
+}
+
+// endpointFromRoute returns the "endpoint" metrics label for the route that handled the request.
+// Classifying by route instead of by the request path keeps the label cardinality bounded and doesn't mistake user data for a resource.
+func endpointFromRoute(route *fiber.Route) string {
+	// Global middlewares are registered for "/" as well (with Fiber's internal "USE" method), but they're not the root handler.
+	// Requests that end in a global middleware (like rate limited ones or 404s) are counted as "other".
+	if route.Path == "/" && route.Method == "USE" {
+		return "other"
+	}
+	if endpoint, ok := routeEndpoints[route.Path]; ok {
+		return endpoint
+	}
+	if strings.HasPrefix(route.Path, "/debug/pprof") {
+		return "pprof"
+	}
+	// It would be valid for Prometheus to have an empty string as label, but it's confusing for users and makes custom legends in Grafana ugly.
+	return "other"
+}
+
+// mediaNameFromContext returns the name of the movie or TV show for logging, like "The Matrix (1999)",
+// or for TV show episodes whose video is in the context, like "Game of Thrones S01E01 – Winter Is Coming".
+func mediaNameFromContext(c *fiber.Ctx, meta cinemeta.Meta) string {
+	video, err := cinemeta.GetVideoFromContext(c.Context())
+	if err != nil {
+		return fmt.Sprintf("%v (%v)", meta.Name, meta.ReleaseInfo)
+	}
+	return fmt.Sprintf("%v S%02dE%02d – %v", meta.Name, video.Season, video.EpisodeNumber(), video.EpisodeName())
+}
+
+// httpRequestsInFlight is the number of requests in the metrics middleware.
+// Like the metrics set that the gauge is registered in, it's process-wide, so with multiple addons in one process it's their sum.
+var httpRequestsInFlight int64
+
+// createMetricsMiddleware creates a middleware that collects request metrics.
+// Only the given types are used as "type" label, others are counted as "other", so clients can't blow up the number of time series.
+func createMetricsMiddleware(types []string) fiber.Handler {
+	// Using GetOrCreate instead of New so that running multiple addons in one process (like in tests) doesn't panic
+
 	// Total number of errors from downstream handlers in the metrics middleware
-	errCounter := metrics.NewCounter("downstream_handlers_errors_total")
+	errCounter := metrics.GetOrCreateCounter("downstream_handlers_errors_total")
+	// The callback doesn't capture anything from this addon, so it doesn't matter which one registered the gauge first
+	metrics.GetOrCreateGauge("http_requests_in_flight", func() float64 {
+		return float64(atomic.LoadInt64(&httpRequestsInFlight))
+	})
 
-	manifestRegex := regexp.MustCompile("^/.*/manifest.json$")
-	catalogRegex := regexp.MustCompile(`^/.*/catalog/.*/.*\.json`)
-	streamRegex := regexp.MustCompile(`^/.*/stream/.*/.*\.json`)
+	knownTypes := make(map[string]struct{}, len(types))
+	for _, t := range types {
+		knownTypes[t] = struct{}{}
+	}
 
 	return func(c *fiber.Ctx) error {
+		start := time.Now()
+		atomic.AddInt64(&httpRequestsInFlight, 1)
+		defer atomic.AddInt64(&httpRequestsInFlight, -1)
+
 		if err := c.Next(); err != nil {
 			errCounter.Inc()
 			return err
 		}
+		duration := time.Since(start)
 
-		path := c.Path()
-		var endpoint string
-		switch path {
-		case "/":
-			endpoint = "root"
-		case "/manifest.json":
-			endpoint = "manifest"
-		case "/configure":
-			endpoint = "configure"
-		case "/health":
-			endpoint = "health"
-		case "/metrics":
-			endpoint = "metrics"
-		}
+		// After c.Next() the route is the last one that handled the request
+		route := c.Route()
+		endpoint := endpointFromRoute(route)
+		status := c.Response().StatusCode()
 
-		if endpoint == "" {
-			if strings.HasPrefix(path, "/catalog") {
-				endpoint = "catalog"
-			} else if strings.HasPrefix(path, "/stream") {
-				endpoint = "stream"
-			} else if strings.HasPrefix(path, "/configure") {
-				endpoint = "configure-other"
-			} else if strings.HasPrefix(path, "/debug/pprof") {
-				endpoint = "pprof"
-			}
+		// Total number of HTTP requests.
+		// With the VictoriaMetrics client library we have to use this workaround for having an equivalent of Prometheus' CounterVec,
+		// see https://pkg.go.dev/github.com/VictoriaMetrics/metrics@v1.12.3#example-Counter-Vec.
+		metrics.GetOrCreateCounter(fmt.Sprintf(`http_requests_total{endpoint="%v", status="%v"}`, endpoint, status)).Inc()
+		metrics.GetOrCreateHistogram(fmt.Sprintf(`http_response_size_bytes{endpoint="%v"}`, endpoint)).Update(float64(len(c.Response().Body())))
+		if status == fiber.StatusNotModified {
+			metrics.GetOrCreateCounter(fmt.Sprintf(`http_etag_not_modified_total{endpoint="%v"}`, endpoint)).Inc()
 		}
 
-		if endpoint == "" {
-			if manifestRegex.MatchString(path) {
-				endpoint = "manifest-data"
-			} else if catalogRegex.MatchString(path) {
-				endpoint = "catalog-data"
-			} else if streamRegex.MatchString(path) {
-				endpoint = "stream-data"
+		// Only the resource endpoints have a type
+		mediaType := ""
+		for _, param := range route.Params {
+			if param == "type" {
+				mediaType = c.Params("type")
+				if _, ok := knownTypes[mediaType]; !ok {
+					mediaType = "other"
+				}
+				break
 			}
 		}
-
-		// It would be valid for Prometheus to have an empty string as label, but it's confusing for users and makes custom legends in Grafana ugly.
-		if endpoint == "" {
-			endpoint = "other"
+		metrics.GetOrCreateHistogram(fmt.Sprintf(`http_request_duration_seconds{endpoint="%v", type="%v"}`, endpoint, mediaType)).Update(duration.Seconds())
+		// Only set when the request reached one of the catalog, stream or meta handlers
+		if handlerDuration, ok := c.Locals("handlerDuration").(time.Duration); ok {
+			metrics.GetOrCreateHistogram(fmt.Sprintf(`handler_duration_seconds{endpoint="%v", type="%v"}`, endpoint, mediaType)).Update(handlerDuration.Seconds())
 		}
 
-		// Total number of HTTP requests.
-		// With the VictoriaMetrics client library we have to use this workaround for having an equivalent of Prometheus' CounterVec,
-		// see https://pkg.go.dev/github.com/VictoriaMetrics/metrics@v1.12.3#example-Counter-Vec.
-		counterName := fmt.Sprintf(`http_requests_total{endpoint="%v", status="%v"}`, endpoint, c.Response().StatusCode())
-		counter := metrics.GetOrCreateCounter(counterName)
-		counter.Add(1)
-
 		return nil
 	}
 }
@@ -196,23 +284,27 @@ func corsMiddleware() fiber.Handler {
 	return cors.New(config)
 }
 
-func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDregexString string, logger *zap.Logger) {
+func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDregexString string, logger logging.Logger) {
 	streamIDregex := regexp.MustCompile(streamIDregexString)
 	if requiresUserData {
 		// Catalog
-		app.Use("/catalog/:type/:id.json", func(c *fiber.Ctx) error {
+		catalogMw := func(c *fiber.Ctx) error {
 			// If user data is required but not sent, let clients know they sent a bad request.
 			// That's better than responding with 404, leading to clients thinking it's a server-side error.
 			return c.SendStatus(fiber.StatusBadRequest)
-		})
-		app.Use("/:userData/catalog/:type/:id.json", func(c *fiber.Ctx) error {
+		}
+		app.Use("/catalog/:type/:id.json", catalogMw)
+		app.Use("/catalog/:type/:id/:extra.json", catalogMw)
+		catalogDataMw := func(c *fiber.Ctx) error {
 			if c.Params("type", "") == "" || c.Params("id", "") == "" {
 				logger.Debug("Rejecting bad request due to missing type or ID")
 				return c.SendStatus(fiber.StatusBadRequest)
 			}
 			c.Locals("isConfigured", true)
 			return c.Next()
-		})
+		}
+		app.Use("/:userData/catalog/:type/:id.json", catalogDataMw)
+		app.Use("/:userData/catalog/:type/:id/:extra.json", catalogDataMw)
 		// Stream
 		app.Use("/stream/:type/:id.json", func(c *fiber.Ctx) error {
 			return c.SendStatus(fiber.StatusBadRequest)
@@ -225,7 +317,7 @@ func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDre
 			}
 			id, err := url.PathUnescape(id)
 			if err != nil {
-				logger.Warn("Couldn't unescape ID", zap.Error(err), zap.String("id", id))
+				logger.Warn("Couldn't unescape ID", "error", err, "id", id)
 				return c.SendStatus(fiber.StatusInternalServerError)
 			}
 			if !streamIDregex.MatchString(id) {
@@ -238,22 +330,18 @@ func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDre
 		})
 	} else {
 		// Catalog
-		app.Use("/catalog/:type/:id.json", func(c *fiber.Ctx) error {
+		catalogMw := func(c *fiber.Ctx) error {
 			if c.Params("type", "") == "" || c.Params("id", "") == "" {
 				logger.Debug("Rejecting bad request due to missing type or ID")
 				return c.SendStatus(fiber.StatusBadRequest)
 			}
 			c.Locals("isConfigured", true)
 			return c.Next()
-		})
-		app.Use("/:userData/catalog/:type/:id.json", func(c *fiber.Ctx) error {
-			if c.Params("type", "") == "" || c.Params("id", "") == "" {
-				logger.Debug("Rejecting bad request due to missing type or ID")
-				return c.SendStatus(fiber.StatusBadRequest)
-			}
-			c.Locals("isConfigured", true)
-			return c.Next()
-		})
+		}
+		app.Use("/catalog/:type/:id.json", catalogMw)
+		app.Use("/catalog/:type/:id/:extra.json", catalogMw)
+		app.Use("/:userData/catalog/:type/:id.json", catalogMw)
+		app.Use("/:userData/catalog/:type/:id/:extra.json", catalogMw)
 		// Stream
 		app.Use("/stream/:type/:id.json", func(c *fiber.Ctx) error {
 			id := c.Params("id", "")
@@ -263,7 +351,7 @@ func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDre
 			}
 			id, err := url.PathUnescape(id)
 			if err != nil {
-				logger.Warn("Couldn't unescape ID", zap.Error(err), zap.String("id", id))
+				logger.Warn("Couldn't unescape ID", "error", err, "id", id)
 				return c.SendStatus(fiber.StatusInternalServerError)
 			}
 			if !streamIDregex.MatchString(id) {
@@ -281,7 +369,7 @@ func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDre
 			}
 			id, err := url.PathUnescape(id)
 			if err != nil {
-				logger.Warn("Couldn't unescape ID", zap.Error(err), zap.String("id", id))
+				logger.Warn("Couldn't unescape ID", "error", err, "id", id)
 				return c.SendStatus(fiber.StatusInternalServerError)
 			}
 			if !streamIDregex.MatchString(id) {
@@ -295,23 +383,72 @@ func addRouteMatcherMiddleware(app *fiber.App, requiresUserData bool, streamIDre
 	}
 }
 
-func createMetaMiddleware(metaClient MetaFetcher, putMetaInHandlerContext, logMediaName bool, logger *zap.Logger) fiber.Handler {
+func createMetaMiddleware(metaClient MetaFetcher, putMetaInHandlerContext, logMediaName, withMetrics bool, logger logging.Logger) fiber.Handler {
+	// Time that the meta middleware adds to requests
+	var durationHistogram *metrics.Histogram
+	if withMetrics {
+		// Using GetOrCreate instead of New so that running multiple addons in one process (like in tests) doesn't panic
+		durationHistogram = metrics.GetOrCreateHistogram("meta_middleware_duration_seconds")
+	}
+
 	return func(c *fiber.Ctx) error {
+		logger := withRequestID(c, logger)
 		// If we should put the meta in the context for *handlers* we get the meta synchronously.
 		// Otherwise we only need it for logging and can get the meta asynchronously.
+		// type and id can never be empty, because that's been checked by a previous middleware.
+		// They're copied because the MetaFetcher can keep them beyond the request, for example as cache key.
+		t := utils.CopyString(c.Params("type", ""))
+		id := utils.CopyString(c.Params("id", ""))
 		if putMetaInHandlerContext {
-			putMetaInContext(c, metaClient, logger)
+			start := time.Now()
+			_, span := startSpan(c, "meta middleware")
+			// The fasthttp context is cancelled when the server shuts down
+			meta, video := fetchMeta(trace.ContextWithSpan(c.Context(), span), metaClient, t, id, logger)
+			span.End()
+			putMetaInContext(c, meta, video)
+			if durationHistogram != nil {
+				durationHistogram.UpdateDuration(start)
+			}
 			return c.Next()
 		} else if logMediaName {
-			var wg sync.WaitGroup
-			wg.Add(1)
+			_, span := startSpan(c, "meta middleware")
+			// The handler writes the request's user values while the meta is fetched, and they're not safe for concurrent use.
+			// So the goroutine gets a snapshot of them instead of the fasthttp context, and only this goroutine writes the results.
+			values := map[interface{}]interface{}{}
+			c.Context().VisitUserValuesAll(func(key, value interface{}) {
+				values[key] = value
+			})
+			cancelCtx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			// Closed when the server shuts down
+			serverDone := c.Context().Done()
 			go func() {
-				putMetaInContext(c, metaClient, logger)
-				wg.Done()
+				select {
+				case <-serverDone:
+					cancel()
+				case <-cancelCtx.Done():
+				}
+			}()
+			ctx := handlerContext{Context: trace.ContextWithSpan(cancelCtx, span), values: values}
+			type result struct {
+				meta  *cinemeta.Meta
+				video *cinemeta.Video
+			}
+			resChan := make(chan result, 1)
+			go func() {
+				meta, video := fetchMeta(ctx, metaClient, t, id, logger)
+				span.End()
+				resChan <- result{meta, video}
 			}()
 			err := c.Next()
-			// Wait so that the meta is in the context when returning to the logging middleware
-			wg.Wait()
+			// Wait so that the meta is in the context when returning to the logging middleware.
+			// Only the waiting adds to the request duration.
+			start := time.Now()
+			res := <-resChan
+			putMetaInContext(c, res.meta, res.video)
+			if durationHistogram != nil {
+				durationHistogram.UpdateDuration(start)
+			}
 			return err
 		} else {
 			return c.Next()
@@ -319,48 +456,72 @@ func createMetaMiddleware(metaClient MetaFetcher, putMetaInHandlerContext, logMe
 	}
 }
 
-func putMetaInContext(c *fiber.Ctx, metaClient MetaFetcher, logger *zap.Logger) {
+// putMetaInContext puts the meta and video into the locals, if they're not nil.
+func putMetaInContext(c *fiber.Ctx, meta *cinemeta.Meta, video *cinemeta.Video) {
+	if meta != nil {
+		c.Locals("meta", *meta)
+	}
+	if video != nil {
+		c.Locals("video", *video)
+	}
+}
+
+// fetchMeta fetches the meta of the requested media, and for TV shows the video of the episode if the MetaFetcher supports it.
+// It returns nil for what it couldn't get.
+// The span in the context becomes the parent of spans that the MetaFetcher creates.
+func fetchMeta(ctx context.Context, metaClient MetaFetcher, t, id string, logger logging.Logger) (*cinemeta.Meta, *cinemeta.Video) {
 	var meta cinemeta.Meta
 	var err error
-	// type and id can never be empty, because that's been checked by a previous middleware
-	t := c.Params("type", "")
-	id := c.Params("id", "")
 	id, err = url.PathUnescape(id)
 	if err != nil {
-		logger.Error("ID in URL parameters couldn't be unescaped", zap.String("id", id))
-		return
+		logger.Error("ID in URL parameters couldn't be unescaped", "id", id)
+		return nil, nil
+	}
+
+	videoID, err := videoid.Parse(id)
+	if err != nil {
+		logger.Warn("Couldn't parse ID", "error", err)
+		return nil, nil
+	} else if !videoID.IsIMDb() {
+		// Cinemeta only knows IMDb IDs
+		logger.Debug("Not getting meta for non-IMDb ID", "id", id)
+		return nil, nil
 	}
 
 	switch t {
 	case "movie":
-		meta, err = metaClient.GetMovie(c.Context(), id)
+		meta, err = metaClient.GetMovie(ctx, videoID.BaseID)
 		if err != nil {
-			logger.Error("Couldn't get movie info with MetaFetcher", zap.Error(err))
-			return
+			logger.Error("Couldn't get movie info with MetaFetcher", "error", err)
+			return nil, nil
 		}
 	case "series":
-		splitID := strings.Split(id, ":")
-		if len(splitID) != 3 {
-			logger.Warn("No 3 elements after splitting TV show ID by \":\"", zap.String("id", id))
-			return
+		if !videoID.HasSeason {
+			logger.Warn("TV show ID doesn't contain season and episode", "id", id)
+			return nil, nil
 		}
-		season, err := strconv.Atoi(splitID[1])
-		if err != nil {
-			logger.Warn("Can't parse season as int", zap.String("season", splitID[1]))
-			return
-		}
-		episode, err := strconv.Atoi(splitID[2])
-		if err != nil {
-			logger.Warn("Can't parse episode as int", zap.String("episode", splitID[2]))
-			return
-		}
-		meta, err = metaClient.GetTVShow(c.Context(), splitID[0], season, episode)
-		if err != nil {
-			logger.Error("Couldn't get TV show info with MetaFetcher", zap.Error(err))
-			return
+		if ef, ok := metaClient.(episodeFetcher); ok {
+			var video cinemeta.Video
+			meta, video, err = ef.GetEpisode(ctx, videoID.BaseID, videoID.Season, videoID.Episode)
+			if errors.Is(err, cinemeta.ErrEpisodeNotFound) {
+				// The TV show meta is still useful
+				logger.Warn("TV show doesn't have the episode", "id", id)
+			} else if err != nil {
+				logger.Error("Couldn't get TV show info with MetaFetcher", "error", err)
+				return nil, nil
+			} else {
+				logger.Debug("Got meta from cinemata client", "meta", fmt.Sprintf("%+v", meta))
+				return &meta, &video
+			}
+		} else {
+			meta, err = metaClient.GetTVShow(ctx, videoID.BaseID, videoID.Season, videoID.Episode)
+			if err != nil {
+				logger.Error("Couldn't get TV show info with MetaFetcher", "error", err)
+				return nil, nil
+			}
 		}
 	}
 
-	logger.Debug("Got meta from cinemata client", zap.String("meta", fmt.Sprintf("%+v", meta)))
-	c.Locals("meta", meta)
+	logger.Debug("Got meta from cinemata client", "meta", fmt.Sprintf("%+v", meta))
+	return &meta, nil
 }
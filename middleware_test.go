@@ -0,0 +1,140 @@
+package stremio
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/cinemeta"
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestRequestIDMiddleware(t *testing.T) {
+	app := fiber.New()
+	app.Use(createRequestIDMiddleware(logging.NewNopLogger()))
+	app.Get("/", func(c *fiber.Ctx) error {
+		return c.SendString(RequestIDFromContext(c.Context()))
+	})
+
+	tests := []struct {
+		name      string
+		requestID string
+		keep      bool
+	}{
+		{"none", "", false},
+		{"UUID", "0b7e2c4e-3f9a-4a8c-9d4e-5c6b7a8d9e0f", true},
+		{"trace ID", "00f067aa0ba902b7:1", true},
+		{"too long", strings.Repeat("a", maxRequestIDlength+1), false},
+		{"quotes", `foo" level=error msg="injected`, false},
+		{"newline", "foo\nbar", false},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tc.requestID != "" {
+				req.Header.Set(fiber.HeaderXRequestID, tc.requestID)
+			}
+			res, err := app.Test(req)
+			require.NoError(t, err)
+			requestID := res.Header.Get(fiber.HeaderXRequestID)
+			require.NotEmpty(t, requestID)
+			if tc.keep {
+				require.Equal(t, tc.requestID, requestID)
+			} else {
+				require.NotEqual(t, tc.requestID, requestID)
+				require.True(t, isValidRequestID(requestID))
+			}
+		})
+	}
+}
+
+type fakeMetaFetcher struct{}
+
+func (fakeMetaFetcher) GetMovie(ctx context.Context, imdbID string) (cinemeta.Meta, error) {
+	return cinemeta.Meta{Name: "The Matrix", ReleaseInfo: "1999"}, nil
+}
+
+func (fakeMetaFetcher) GetTVShow(ctx context.Context, imdbID string, season int, episode int) (cinemeta.Meta, error) {
+	return cinemeta.Meta{Name: "Game of Thrones"}, nil
+}
+
+func (fakeMetaFetcher) GetEpisode(ctx context.Context, imdbID string, season int, episode int) (cinemeta.Meta, cinemeta.Video, error) {
+	// Give the handler time to write its locals at the same time
+	time.Sleep(10 * time.Millisecond)
+	return cinemeta.Meta{Name: "Game of Thrones"}, cinemeta.Video{Season: season, Episode: episode, Name: "Winter Is Coming"}, nil
+}
+
+// TestMetaMiddlewareForLogging checks that the meta is fetched concurrently to the handler when it's only needed for logging.
+// Run with -race to check that the request's locals aren't accessed concurrently.
+func TestMetaMiddlewareForLogging(t *testing.T) {
+	streamHandler := convertStreamHandler(func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		time.Sleep(10 * time.Millisecond)
+		return []StreamItem{{URL: "https://example.com/foo.mp4"}}, nil
+	})
+	findHandler := func(t, id string) (handler, bool) { return streamHandler, true }
+
+	app := fiber.New()
+	app.Use(func(c *fiber.Ctx) error {
+		if err := c.Next(); err != nil {
+			return err
+		}
+		// Like the logging middleware
+		meta, err := cinemeta.GetMetaFromContext(c.Context())
+		if err != nil {
+			return err
+		}
+		return c.SendString(mediaNameFromContext(c, meta))
+	})
+	app.Use("/stream/:type/:id.json", createMetaMiddleware(fakeMetaFetcher{}, false, true, false, logging.NewNopLogger()))
+	app.Get("/stream/:type/:id.json", createStreamHandler(findHandler, 0, false, false, time.Second, 0, context.Background(), logging.NewNopLogger(), nil, false))
+
+	for i := 0; i < 5; i++ {
+		res, err := app.Test(httptest.NewRequest("GET", "/stream/series/tt0944947:1:1.json", nil))
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Equal(t, "Game of Thrones S01E01 – Winter Is Coming", string(body))
+	}
+}
+
+// recordingMetaFetcher records the IDs it's called with, like a cache that uses them as keys.
+type recordingMetaFetcher struct {
+	lock *sync.Mutex
+	ids  []string
+}
+
+func (f *recordingMetaFetcher) GetMovie(ctx context.Context, imdbID string) (cinemeta.Meta, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	f.ids = append(f.ids, imdbID)
+	return cinemeta.Meta{Name: "The Matrix"}, nil
+}
+
+func (f *recordingMetaFetcher) GetTVShow(ctx context.Context, imdbID string, season int, episode int) (cinemeta.Meta, error) {
+	return f.GetMovie(ctx, imdbID)
+}
+
+func TestMetaMiddlewareCopiesIDs(t *testing.T) {
+	fetcher := &recordingMetaFetcher{lock: &sync.Mutex{}}
+	app := fiber.New()
+	app.Use("/stream/:type/:id.json", createMetaMiddleware(fetcher, true, false, false, logging.NewNopLogger()))
+	app.Get("/stream/:type/:id.json", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	ids := []string{"tt1111111", "tt2222222", "tt3333333"}
+	for _, id := range ids {
+		res, err := app.Test(httptest.NewRequest("GET", "/stream/movie/"+id+".json", nil))
+		require.NoError(t, err)
+		require.Equal(t, fiber.StatusOK, res.StatusCode)
+	}
+	// The IDs don't point into the request buffers, which are reused for later requests
+	fetcher.lock.Lock()
+	defer fetcher.lock.Unlock()
+	require.Equal(t, ids, fetcher.ids)
+}
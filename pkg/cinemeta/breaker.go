@@ -0,0 +1,72 @@
+package cinemeta
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned when requests to Cinemeta aren't made, because too many of the previous ones failed.
+var ErrCircuitOpen = errors.New("circuit breaker is open")
+
+// circuitBreaker stops requests to Cinemeta after a number of consecutive failures, so that callers fail fast while it's down.
+// After the cooldown it lets a single request through. If it succeeds, the circuit is closed again, otherwise the cooldown starts again.
+// A nil circuitBreaker allows all requests.
+type circuitBreaker struct {
+	threshold int
+	cooldown  time.Duration
+	lock      *sync.Mutex
+	failures  int
+	openedAt  time.Time
+	probing   bool
+}
+
+func newCircuitBreaker(threshold int, cooldown time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		threshold: threshold,
+		cooldown:  cooldown,
+		lock:      &sync.Mutex{},
+	}
+}
+
+// allow returns true if a request can be made.
+// Each allowed request must be followed by a call to either success or failure.
+func (b *circuitBreaker) allow() bool {
+	if b == nil {
+		return true
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if b.failures < b.threshold {
+		return true
+	} else if time.Since(b.openedAt) < b.cooldown || b.probing {
+		return false
+	}
+	b.probing = true
+	return true
+}
+
+// success records a request that reached Cinemeta, even if it didn't know the ID.
+func (b *circuitBreaker) success() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures = 0
+	b.probing = false
+}
+
+// failure records a request that failed due to Cinemeta being unreachable or having a server error.
+func (b *circuitBreaker) failure() {
+	if b == nil {
+		return
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	b.failures++
+	b.probing = false
+	if b.failures >= b.threshold {
+		b.openedAt = time.Now()
+	}
+}
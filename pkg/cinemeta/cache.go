@@ -16,7 +16,7 @@ type CacheItem struct {
 // Cache is the interface that the cinemeta client uses for caching meta.
 // A package user must pass an implementation of this interface.
 // Usually you create a simple wrapper around an existing cache package.
-// An example implementation is the InMemoryCache in this package.
+// The LRUCache in this package is a bounded in-memory implementation that's suited for production use.
 type Cache interface {
 	Set(key string, movie Meta) error
 	Get(key string) (Meta, time.Time, bool, error)
@@ -25,7 +25,8 @@ type Cache interface {
 var _ Cache = (*InMemoryCache)(nil)
 
 // InMemoryCache is an example implementation of the Cache interface.
-// It doesn't persist its data, so it's not suited for production use of the cinemeta package.
+// It doesn't persist its data and never evicts entries, so it's not suited for production use of the cinemeta package.
+// Use LRUCache instead.
 type InMemoryCache struct {
 	cache map[string]CacheItem
 	lock  *sync.RWMutex
@@ -58,3 +59,11 @@ func (c *InMemoryCache) Get(key string) (Meta, time.Time, bool, error) {
 	cacheItem, found := c.cache[key]
 	return cacheItem.Meta, cacheItem.Created, found, nil
 }
+
+// PreviewCache is the interface that the cinemeta client uses for caching catalogs and search results.
+// It's optional. Without it, each GetCatalog and Search call leads to a request to Cinemeta.
+// The LRUPreviewCache in this package is a bounded in-memory implementation.
+type PreviewCache interface {
+	Set(key string, previews []MetaPreview) error
+	Get(key string) ([]MetaPreview, time.Time, bool, error)
+}
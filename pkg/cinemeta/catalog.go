@@ -0,0 +1,178 @@
+package cinemeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+// Catalog IDs of Cinemeta
+const (
+	// CatalogTop is the catalog of popular movies or TV shows. It's also the one that supports search.
+	CatalogTop = "top"
+	// CatalogYear is the catalog of movies or TV shows by release year. It requires the year as genre.
+	CatalogYear = "year"
+	// CatalogIMDbRating is the catalog of movies or TV shows by IMDb rating.
+	CatalogIMDbRating = "imdbRating"
+)
+
+// CatalogExtra are the optional arguments for a catalog request.
+type CatalogExtra struct {
+	// Genre, like "Action". For the CatalogYear catalog it's the year, like "2020".
+	Genre string
+	// Number of items to skip, for pagination. Cinemeta returns up to 100 items per request.
+	Skip int
+	// Search query, only supported by the CatalogTop catalog.
+	Search string
+}
+
+// encode encodes the extra the way Stremio does in catalog URLs, like "genre=Action&skip=100".
+func (e CatalogExtra) encode() string {
+	var parts []string
+	if e.Search != "" {
+		parts = append(parts, "search="+escapeExtraValue(e.Search))
+	}
+	if e.Genre != "" {
+		parts = append(parts, "genre="+escapeExtraValue(e.Genre))
+	}
+	if e.Skip > 0 {
+		parts = append(parts, "skip="+strconv.Itoa(e.Skip))
+	}
+	return strings.Join(parts, "&")
+}
+
+func escapeExtraValue(value string) string {
+	// Like JavaScript's encodeURIComponent, which Stremio uses
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+type cinemetaCatalogResponse struct {
+	Metas []MetaPreview `json:"metas"`
+}
+
+// GetCatalog returns the items of one of Cinemeta's catalogs, like CatalogTop, either from the preview cache or from Cinemeta.
+// The type is "movie" or "series", like in Stremio.
+// Like the meta requests, concurrent requests for the same catalog are deduplicated, and retries,
+// the circuit breaker and serving stale cache items apply according to the client's options.
+// Without a PreviewCache in the client's options, the results aren't cached.
+func (c *Client) GetCatalog(ctx context.Context, t string, catalogID string, extra CatalogExtra) ([]MetaPreview, error) {
+	if t != "movie" && t != "series" {
+		return nil, fmt.Errorf("Unsupported type %q", t)
+	}
+	// Like the path, which is the cache key, the span attributes are kept beyond the call
+	t, catalogID = strings.Clone(t), strings.Clone(catalogID)
+	path := "/catalog/" + t + "/" + url.PathEscape(catalogID)
+	if encodedExtra := extra.encode(); encodedExtra != "" {
+		path += "/" + encodedExtra
+	}
+	path += ".json"
+
+	ctx, span := c.tracer(ctx).Start(ctx, "cinemeta.getCatalog", trace.WithAttributes(
+		attribute.String("cinemeta.type", t),
+		attribute.String("cinemeta.catalog_id", catalogID),
+	))
+	defer span.End()
+	previews, err := c.getCatalogTraced(ctx, span, path)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	return previews, err
+}
+
+// Search searches Cinemeta's movies or TV shows by title, for example for mapping a release name to an IMDb ID.
+// The type is "movie" or "series", like in Stremio.
+// It's a shortcut for GetCatalog with the CatalogTop catalog and the query in the extra.
+func (c *Client) Search(ctx context.Context, t string, query string) ([]MetaPreview, error) {
+	return c.GetCatalog(ctx, t, CatalogTop, CatalogExtra{Search: query})
+}
+
+func (c *Client) getCatalogTraced(ctx context.Context, span trace.Span, path string) ([]MetaPreview, error) {
+	logger := c.logger.With("catalog", path)
+
+	// For returning it when requesting Cinemeta fails
+	var stale []MetaPreview
+	if c.previewCache != nil {
+		previews, created, found, err := c.previewCache.Get(path)
+		if err != nil {
+			logger.Error("Couldn't get catalog from cache", "error", err)
+			c.metrics.catalogCache("error")
+		} else if !found {
+			c.metrics.catalogCache("miss")
+		} else if time.Since(created) > c.catalogTTL {
+			c.metrics.catalogCache("expired")
+			if c.serveStale && time.Since(created.Add(c.catalogTTL)) <= c.maxStaleAge {
+				stale = previews
+			}
+		} else {
+			logger.Debug("Hit cache for catalog, returning result")
+			c.metrics.catalogCache("hit")
+			span.SetAttributes(attribute.String("cinemeta.cache", "hit"))
+			return previews, nil
+		}
+	}
+
+	var err error
+	resChan := c.inFlight.DoChan("catalog"+path, func() (interface{}, error) {
+		ctx, cancel := c.flightContext(ctx)
+		defer cancel()
+		return withRetries(ctx, c, logger, func() ([]MetaPreview, error) {
+			return c.fetchCatalog(ctx, path, logger)
+		})
+	})
+	select {
+	case res := <-resChan:
+		if res.Shared {
+			c.metrics.coalesced()
+			span.SetAttributes(attribute.Bool("cinemeta.coalesced", true))
+		}
+		err = res.Err
+		if err == nil {
+			return res.Val.([]MetaPreview), nil
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	if stale != nil {
+		logger.Warn("Couldn't get catalog from Cinemeta, returning expired cache item", "error", err)
+		c.metrics.catalogCache("stale")
+		span.SetAttributes(attribute.String("cinemeta.cache", "stale"))
+		return stale, nil
+	}
+	return nil, err
+}
+
+// fetchCatalog requests the catalog from Cinemeta and fills the preview cache.
+func (c *Client) fetchCatalog(ctx context.Context, path string, logger logging.Logger) ([]MetaPreview, error) {
+	resBody, err := c.get(ctx, c.baseURL+path, "catalog")
+	if err != nil {
+		return nil, err
+	}
+	cineRes := cinemetaCatalogResponse{}
+	if err := json.Unmarshal(resBody, &cineRes); err != nil {
+		c.metrics.error("decode")
+		return nil, fmt.Errorf("Couldn't unmarshal response body: %v", err)
+	}
+	if cineRes.Metas == nil {
+		cineRes.Metas = []MetaPreview{}
+	}
+
+	// Fill cache
+	if c.previewCache != nil {
+		if err := c.previewCache.Set(path, cineRes.Metas); err != nil {
+			logger.Error("Couldn't cache catalog", "error", err)
+		}
+	}
+
+	return cineRes.Metas, nil
+}
@@ -3,49 +3,153 @@ package cinemeta
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"strings"
+	"sync"
 	"time"
 
-	"go.uber.org/zap"
-	"go.uber.org/zap/zapcore"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
 )
 
+// tracerName is the name of the OpenTelemetry tracer, which is the package's import path by convention.
+const tracerName = "github.com/deflix-tv/go-stremio/pkg/cinemeta"
+
 // ClientOptions are the options for the Cinemeta client.
 type ClientOptions struct {
 	// The base URL for Cinemeta.
 	// Default "https://v3-cinemeta.strem.io".
 	BaseURL string
-	// Timeout for requests.
+	// Timeout for requests. When HTTPClient is set, its own timeout is used instead, unless it's 0.
 	// A more customizable cancellation can be achieved with the context,
 	// but it can never be *longer* than this timeout.
+	// Requests that are shared by concurrent callers are bounded by this timeout times the number of attempts.
 	// Default 2 seconds.
 	Timeout time.Duration
 	// Max age of items in the cache.
 	// Default 30 days.
 	TTL time.Duration
+	// Flag for indicating whether to collect metrics about requests to Cinemeta and cache lookups.
+	// They're registered in the default VictoriaMetrics set, so they're exposed on go-stremio's "/metrics" endpoint
+	// when its Metrics option is enabled, or with metrics.WritePrometheus() when you use the client on its own.
+	// Default false.
+	Metrics bool
+	// OpenTelemetry tracer provider for creating spans for GetMovie and GetTVShow calls and the requests to Cinemeta.
+	// Default nil, meaning the provider of the span in the context that's passed to the methods is used.
+	// So when there's no span in the context, no spans are created.
+	TracerProvider trace.TracerProvider
+	// Max age of the entries in the negative cache, which contains IDs that Cinemeta doesn't know.
+	// Requests for these IDs fail with ErrMetaNotFound without requesting Cinemeta again until the entry expires.
+	// A negative value disables the negative cache.
+	// Default 10 minutes.
+	NegativeTTL time.Duration
+	// Number of retries for requests that fail due to network errors or server errors (5xx and 429 status codes).
+	// Default 0, meaning a single attempt.
+	Retries int
+	// Base delay between retries. The delay doubles with each retry and is randomized ("full jitter"),
+	// so that many clients don't retry at the same time.
+	// Default 100 milliseconds.
+	RetryBackoff time.Duration
+	// Number of consecutive failed requests after which the client stops requesting Cinemeta and fails fast with ErrCircuitOpen.
+	// Retries of the same request don't count separately.
+	// Default 0, meaning no circuit breaker.
+	CircuitBreakerThreshold int
+	// Time after which the circuit breaker lets a single request through, to check whether Cinemeta is available again.
+	// Default 30 seconds.
+	CircuitBreakerCooldown time.Duration
+	// Flag for indicating whether to return expired cache entries when requesting Cinemeta fails,
+	// including when the circuit breaker is open or the context is done.
+	// Default false.
+	ServeStaleOnError bool
+	// Max time after expiring during which cache entries are still returned when requesting Cinemeta fails.
+	// Only relevant when ServeStaleOnError is true. The cache must keep entries for at least TTL + MaxStaleAge,
+	// which the defaults of LRUCache and FileCache do.
+	// Default 7 days.
+	MaxStaleAge time.Duration
+	// Cache for the results of GetCatalog and Search.
+	// Default nil, meaning they're not cached. NewLRUPreviewCache creates a bounded in-memory cache.
+	PreviewCache PreviewCache
+	// Max age of items in the preview cache. Catalogs change more often than metas, so it's shorter than the TTL.
+	// Default 6 hours.
+	CatalogTTL time.Duration
+	// HTTP client for the requests to Cinemeta, for example for routing them through a proxy.
+	// When it's set, Timeout and Transport are ignored, so configure them in the client itself.
+	// Default nil, meaning a new client with the Timeout and Transport is created.
+	HTTPClient *http.Client
+	// Transport for the HTTP client that's created when HTTPClient isn't set,
+	// for example for a custom proxy or a fake RoundTripper in tests.
+	// Default nil, meaning http.DefaultTransport is used.
+	Transport http.RoundTripper
+	// Headers that are added to each request, for example for authenticating with a Cinemeta mirror.
+	// Default nil.
+	Headers http.Header
+	// User-Agent header for the requests. It takes precedence over a User-Agent in Headers.
+	// Default "", meaning Go's default User-Agent is used.
+	UserAgent string
 }
 
 // DefaultClientOpts is an options object with sensible defaults.
 var DefaultClientOpts = ClientOptions{
 	BaseURL: "https://v3-cinemeta.strem.io",
 	// HTTP client timeout
-	Timeout: 2 * time.Second,
-	TTL:     30 * 24 * time.Hour, // 30 days
+	Timeout:                2 * time.Second,
+	TTL:                    30 * 24 * time.Hour, // 30 days
+	NegativeTTL:            10 * time.Minute,
+	RetryBackoff:           100 * time.Millisecond,
+	CircuitBreakerCooldown: 30 * time.Second,
+	MaxStaleAge:            7 * 24 * time.Hour, // 7 days
+	CatalogTTL:             6 * time.Hour,
 }
 
+// maxNotFound is the max number of entries in the negative cache, so that requests for random IDs can't exhaust the memory.
+const maxNotFound = 10000
+
+// ErrMetaNotFound is returned when Cinemeta doesn't know the requested ID.
+var ErrMetaNotFound = errors.New("meta not found")
+
 // Client is the Cinemeta client.
 type Client struct {
 	baseURL    string
 	httpClient *http.Client
 	cache      Cache
-	logger     *zap.Logger
+	logger     logging.Logger
 	ttl        time.Duration
+	// nil when metrics are disabled
+	metrics *clientMetrics
+	// nil when the provider from the context should be used
+	tracerProvider trace.TracerProvider
+	// Deduplicates concurrent requests for the same ID
+	inFlight *singleflight.Group
+	// Max duration of a shared request including retries, as it's not cancelled by the callers' contexts
+	flightTimeout time.Duration
+	// Negative cache, with the time when Cinemeta didn't know the ID
+	notFound     map[string]time.Time
+	notFoundLock *sync.Mutex
+	negativeTTL  time.Duration
+	retries      int
+	retryBackoff time.Duration
+	// nil when the circuit breaker is disabled
+	breaker     *circuitBreaker
+	serveStale  bool
+	maxStaleAge time.Duration
+	// nil when catalogs aren't cached
+	previewCache PreviewCache
+	catalogTTL   time.Duration
+	headers      http.Header
 }
 
 // NewClient creates a new Cinemeta client.
-func NewClient(opts ClientOptions, cache Cache, logger *zap.Logger) *Client {
+// The logger can be nil, in which case nothing is logged.
+func NewClient(opts ClientOptions, cache Cache, logger logging.Logger) *Client {
 	// Set defaults if necessary.
 	// A TTL of 0 is allowed.
 	if opts.BaseURL == "" {
@@ -57,15 +161,78 @@ func NewClient(opts ClientOptions, cache Cache, logger *zap.Logger) *Client {
 	if opts.TTL == 0 {
 		opts.TTL = DefaultClientOpts.TTL
 	}
+	if opts.NegativeTTL == 0 {
+		opts.NegativeTTL = DefaultClientOpts.NegativeTTL
+	}
+	if opts.RetryBackoff == 0 {
+		opts.RetryBackoff = DefaultClientOpts.RetryBackoff
+	}
+	if opts.CircuitBreakerCooldown == 0 {
+		opts.CircuitBreakerCooldown = DefaultClientOpts.CircuitBreakerCooldown
+	}
+	if opts.MaxStaleAge == 0 {
+		opts.MaxStaleAge = DefaultClientOpts.MaxStaleAge
+	}
+	if opts.CatalogTTL == 0 {
+		opts.CatalogTTL = DefaultClientOpts.CatalogTTL
+	}
+	var breaker *circuitBreaker
+	if opts.CircuitBreakerThreshold > 0 {
+		breaker = newCircuitBreaker(opts.CircuitBreakerThreshold, opts.CircuitBreakerCooldown)
+	}
+	if logger == nil {
+		logger = logging.NewNopLogger()
+	}
+
+	var m *clientMetrics
+	if opts.Metrics {
+		m = newClientMetrics()
+	}
+
+	httpClient := opts.HTTPClient
+	if httpClient == nil {
+		httpClient = &http.Client{
+			Timeout:   opts.Timeout,
+			Transport: opts.Transport,
+		}
+	}
+	requestTimeout := opts.Timeout
+	if opts.HTTPClient != nil && opts.HTTPClient.Timeout > 0 {
+		requestTimeout = opts.HTTPClient.Timeout
+	}
+	// Copied so that later changes by the caller don't lead to data races, and with canonical keys,
+	// in case the caller created the map with a literal
+	headers := http.Header{}
+	for key, values := range opts.Headers {
+		for _, value := range values {
+			headers.Add(key, value)
+		}
+	}
+	if opts.UserAgent != "" {
+		headers.Set("User-Agent", opts.UserAgent)
+	}
 
 	return &Client{
-		baseURL: opts.BaseURL,
-		httpClient: &http.Client{
-			Timeout: opts.Timeout,
-		},
-		cache:  cache,
-		logger: logger,
-		ttl:    opts.TTL,
+		baseURL:        opts.BaseURL,
+		httpClient:     httpClient,
+		cache:          cache,
+		logger:         logger,
+		ttl:            opts.TTL,
+		metrics:        m,
+		tracerProvider: opts.TracerProvider,
+		inFlight:       &singleflight.Group{},
+		flightTimeout:  requestTimeout * time.Duration(opts.Retries+1),
+		notFound:       map[string]time.Time{},
+		notFoundLock:   &sync.Mutex{},
+		negativeTTL:    opts.NegativeTTL,
+		retries:        opts.Retries,
+		retryBackoff:   opts.RetryBackoff,
+		breaker:        breaker,
+		serveStale:     opts.ServeStaleOnError,
+		maxStaleAge:    opts.MaxStaleAge,
+		previewCache:   opts.PreviewCache,
+		catalogTTL:     opts.CatalogTTL,
+		headers:        headers,
 	}
 }
 
@@ -83,75 +250,322 @@ func (c *Client) GetMovie(ctx context.Context, imdbID string) (Meta, error) {
 // The context can control the lifetime of the request, and if for example the timeout is shorter
 // than the HTTP client's configured timeout then it takes precedence.
 // If no timeout is set in the context, the HTTP client's timeout takes effect.
+// The returned meta is the one of the TV show, regardless of the season and episode.
+// The season and episode parameters are deprecated: they're only used for logging and only remain for compatibility
+// with the MetaFetcher interface. Use GetEpisode for also getting the episode.
 func (c *Client) GetTVShow(ctx context.Context, imdbID string, season int, episode int) (Meta, error) {
 	return c.getMeta(ctx, tvShow, imdbID, season, episode)
 }
 
+// GetEpisode returns the meta object of the TV show and the video of the episode, like GetTVShow.
+// The meta is cached once per TV show, so getting multiple episodes of the same TV show only leads to one request to Cinemeta.
+// If the TV show exists but doesn't have the episode, the meta is returned along with ErrEpisodeNotFound.
+func (c *Client) GetEpisode(ctx context.Context, imdbID string, season int, episode int) (Meta, Video, error) {
+	meta, err := c.getMeta(ctx, tvShow, imdbID, season, episode)
+	if err != nil {
+		return Meta{}, Video{}, err
+	}
+	video, ok := meta.FindEpisode(season, episode)
+	if !ok {
+		return meta, Video{}, ErrEpisodeNotFound
+	}
+	return meta, video, nil
+}
+
 // GetMeta returns the meta object either from the cache or from Cinemeta.
 // It automatically fills the cache with new Cinemeta responses.
 // The context can control the lifetime of the request, and if for example the timeout is shorter
 // than the HTTP client's configured timeout then it takes precedence.
 // If no timeout is set in the context, the HTTP client's timeout takes effect.
 func (c *Client) getMeta(ctx context.Context, t mediaType, imdbID string, season int, episode int) (Meta, error) {
-	var zapFieldIMDbID zapcore.Field
+	// The ID is kept beyond the call, as cache key and in the shared request, so it mustn't share memory with
+	// a string that the caller reuses, like one that points into a request buffer
+	imdbID = strings.Clone(imdbID)
+	ctx, span := c.tracer(ctx).Start(ctx, "cinemeta.getMeta", trace.WithAttributes(
+		attribute.String("cinemeta.type", t.String()),
+		attribute.String("cinemeta.imdb_id", imdbID),
+	))
+	meta, err := c.getMetaTraced(ctx, span, t, imdbID, season, episode)
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+	return meta, err
+}
+
+// tracer returns the tracer from the configured provider, or if none was configured, the provider of the span in the context.
+func (c *Client) tracer(ctx context.Context) trace.Tracer {
+	tracerProvider := c.tracerProvider
+	if tracerProvider == nil {
+		tracerProvider = trace.SpanFromContext(ctx).TracerProvider()
+	}
+	return tracerProvider.Tracer(tracerName)
+}
+
+func (c *Client) getMetaTraced(ctx context.Context, span trace.Span, t mediaType, imdbID string, season int, episode int) (Meta, error) {
+	var logger logging.Logger
 	switch t {
 	case movie:
-		zapFieldIMDbID = zap.String("imdbID", imdbID)
+		logger = c.logger.With("imdbID", imdbID)
 	case tvShow:
-		zapFieldIMDbID = zap.String("imdbID", fmt.Sprintf("%v:%v:%v", imdbID, season, episode))
+		logger = c.logger.With("imdbID", fmt.Sprintf("%v:%v:%v", imdbID, season, episode))
 	}
 
 	// Check cache first
 	meta, created, found, err := c.cache.Get(imdbID)
+	// For returning it when requesting Cinemeta fails
+	var stale *Meta
 	if err != nil {
-		c.logger.Error("Couldn't decode meta", zap.Error(err), zapFieldIMDbID)
+		logger.Error("Couldn't decode meta", "error", err)
+		c.metrics.cache("error")
+		span.SetAttributes(attribute.String("cinemeta.cache", "error"))
 	} else if !found {
-		c.logger.Debug("Meta not found in cache", zapFieldIMDbID)
+		logger.Debug("Meta not found in cache")
+		c.metrics.cache("miss")
+		span.SetAttributes(attribute.String("cinemeta.cache", "miss"))
 	} else if time.Since(created) > c.ttl {
 		expiredSince := time.Since(created.Add(c.ttl))
-		c.logger.Debug("Hit cache for meta, but item is expired", zap.Duration("expiredSince", expiredSince), zapFieldIMDbID)
+		logger.Debug("Hit cache for meta, but item is expired", "expiredSince", expiredSince)
+		c.metrics.cache("expired")
+		span.SetAttributes(attribute.String("cinemeta.cache", "expired"))
+		if c.serveStale && expiredSince <= c.maxStaleAge {
+			stale = &meta
+		}
 	} else {
-		c.logger.Debug("Hit cache for meta, returning result")
+		logger.Debug("Hit cache for meta, returning result")
+		c.metrics.cache("hit")
+		span.SetAttributes(attribute.String("cinemeta.cache", "hit"))
 		return meta, nil
 	}
 
-	var reqUrl string
-	switch t {
-	case movie:
-		reqUrl = c.baseURL + "/meta/movie/" + imdbID + ".json"
-	case tvShow:
-		reqUrl = c.baseURL + "/meta/series/" + imdbID + ".json"
+	// Unknown IDs are cached separately and for a shorter time
+	if c.negativeTTL > 0 {
+		c.notFoundLock.Lock()
+		notFoundSince, ok := c.notFound[imdbID]
+		if ok && time.Since(notFoundSince) > c.negativeTTL {
+			delete(c.notFound, imdbID)
+			ok = false
+		}
+		c.notFoundLock.Unlock()
+		if ok {
+			logger.Debug("Hit negative cache for meta")
+			c.metrics.cache("negative")
+			span.SetAttributes(attribute.String("cinemeta.cache", "negative"))
+			return Meta{}, fmt.Errorf("%w (cached)", ErrMetaNotFound)
+		}
+	}
+
+	// Concurrent requests for the same ID share one request to Cinemeta.
+	// The request isn't cancelled when the context of the first caller is cancelled, because others might still wait for it,
+	// but each caller only waits as long as its own context allows.
+	resChan := c.inFlight.DoChan(t.String()+"/"+imdbID, func() (interface{}, error) {
+		ctx, cancel := c.flightContext(ctx)
+		defer cancel()
+		return withRetries(ctx, c, logger, func() (Meta, error) {
+			return c.fetch(ctx, t, imdbID, logger)
+		})
+	})
+	select {
+	case res := <-resChan:
+		if res.Shared {
+			c.metrics.coalesced()
+			span.SetAttributes(attribute.Bool("cinemeta.coalesced", true))
+		}
+		err = res.Err
+		if err == nil {
+			return res.Val.(Meta), nil
+		}
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	// Unknown IDs aren't errors that stale entries should cover up
+	if stale != nil && !errors.Is(err, ErrMetaNotFound) {
+		logger.Warn("Couldn't get meta from Cinemeta, returning expired cache item", "error", err)
+		c.metrics.cache("stale")
+		span.SetAttributes(attribute.String("cinemeta.cache", "stale"))
+		return *stale, nil
 	}
+	return Meta{}, err
+}
+
+// flightContext returns a context for a request that's shared by concurrent callers.
+// It keeps the values of the caller's context, like the span, but isn't cancelled with it. Instead it's bounded by the flight timeout.
+func (c *Client) flightContext(ctx context.Context) (context.Context, context.CancelFunc) {
+	return context.WithTimeout(context.WithoutCancel(ctx), c.flightTimeout)
+}
+
+// transientError is an error that might not occur when retrying the request, like a network error or a 503 response.
+type transientError struct {
+	err error
+}
+
+func (e transientError) Error() string {
+	return e.err.Error()
+}
+
+func (e transientError) Unwrap() error {
+	return e.err
+}
 
-	// Then check web service
+// withRetries calls f and retries it with jittered exponential backoff for transient errors.
+// It fails fast while the circuit breaker is open.
+func withRetries[T any](ctx context.Context, c *Client, logger logging.Logger, f func() (T, error)) (T, error) {
+	var zero T
+	if !c.breaker.allow() {
+		c.metrics.error("circuit_open")
+		return zero, ErrCircuitOpen
+	}
+	for attempt := 0; ; attempt++ {
+		res, err := f()
+		var transientErr transientError
+		if err == nil || !errors.As(err, &transientErr) {
+			// Cinemeta responded, even if maybe with a 404
+			c.breaker.success()
+			return res, err
+		} else if attempt >= c.retries {
+			c.breaker.failure()
+			return zero, err
+		}
+		// Full jitter: a random delay between 0 and the exponentially growing backoff
+		var backoff time.Duration
+		if c.retryBackoff > 0 {
+			backoff = time.Duration(rand.Int63n(int64(c.retryBackoff) << min(attempt, 16)))
+		}
+		logger.Debug("Retrying request to Cinemeta", "error", err, "attempt", attempt+1, "backoff", backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			c.breaker.failure()
+			return zero, err
+		}
+	}
+}
+
+// newRequest creates a GET request with the configured headers.
+func (c *Client) newRequest(ctx context.Context, reqUrl string) (*http.Request, error) {
 	req, err := http.NewRequestWithContext(ctx, "GET", reqUrl, nil)
 	if err != nil {
-		return Meta{}, fmt.Errorf("Couldn't create request: %v", err)
+		return nil, fmt.Errorf("Couldn't create request: %v", err)
+	}
+	// Cloned, because the header values must not be shared between requests
+	req.Header = c.headers.Clone()
+	return req, nil
+}
+
+// errStatusNotFound is returned by get when Cinemeta responds with 404.
+var errStatusNotFound = errors.New("Cinemeta responded with 404")
+
+// get requests the URL from Cinemeta and returns the response body.
+// The resource type is used for metrics and is one of "movie", "series" and "catalog".
+func (c *Client) get(ctx context.Context, reqUrl string, resourceType string) ([]byte, error) {
+	ctx, reqSpan := c.tracer(ctx).Start(ctx, "GET", trace.WithSpanKind(trace.SpanKindClient), trace.WithAttributes(
+		semconv.HTTPRequestMethodKey.String("GET"),
+		semconv.URLFull(reqUrl),
+	))
+	defer reqSpan.End()
+	req, err := c.newRequest(ctx, reqUrl)
+	if err != nil {
+		return nil, err
 	}
+	start := time.Now()
 	res, err := c.httpClient.Do(req)
 	if err != nil {
-		return Meta{}, fmt.Errorf("Couldn't GET %v: %v", reqUrl, err)
+		c.metrics.request(resourceType, start)
+		c.metrics.error("request")
+		return nil, transientError{fmt.Errorf("Couldn't GET %v: %v", reqUrl, err)}
 	}
 	defer res.Body.Close()
-	if res.StatusCode != http.StatusOK {
-		return Meta{}, fmt.Errorf("Bad GET response: %v", res.StatusCode)
+	reqSpan.SetAttributes(semconv.HTTPResponseStatusCode(res.StatusCode))
+	if res.StatusCode == http.StatusNotFound {
+		c.metrics.request(resourceType, start)
+		c.metrics.error("status")
+		return nil, errStatusNotFound
+	} else if res.StatusCode != http.StatusOK {
+		reqSpan.SetStatus(codes.Error, "")
+		c.metrics.request(resourceType, start)
+		c.metrics.error("status")
+		err := fmt.Errorf("Bad GET response: %v", res.StatusCode)
+		if res.StatusCode >= 500 || res.StatusCode == http.StatusTooManyRequests {
+			return nil, transientError{err}
+		}
+		return nil, err
 	}
 	resBody, err := ioutil.ReadAll(res.Body)
+	// The duration includes reading the body, because that's part of the time the request takes
+	c.metrics.request(resourceType, start)
 	if err != nil {
-		return Meta{}, fmt.Errorf("Couldn't read response body: %v", err)
+		c.metrics.error("read")
+		return nil, fmt.Errorf("Couldn't read response body: %v", err)
+	}
+	return resBody, nil
+}
+
+// fetch requests the meta from Cinemeta and fills the cache.
+func (c *Client) fetch(ctx context.Context, t mediaType, imdbID string, logger logging.Logger) (Meta, error) {
+	reqUrl := c.baseURL + "/meta/" + t.resourceType() + "/" + imdbID + ".json"
+	resBody, err := c.get(ctx, reqUrl, t.resourceType())
+	if errors.Is(err, errStatusNotFound) {
+		c.setNotFound(imdbID)
+		return Meta{}, fmt.Errorf("%w: %v", ErrMetaNotFound, err)
+	} else if err != nil {
+		return Meta{}, err
 	}
 	cineRes := cinemetaResponse{}
 	if err := json.Unmarshal(resBody, &cineRes); err != nil {
+		c.metrics.error("decode")
 		return Meta{}, fmt.Errorf("Couldn't unmarshal response body: %v", err)
 	}
 	if cineRes.Meta.Name == "" {
-		return Meta{}, fmt.Errorf("Couldn't find %v name in Cinemeta response", t)
+		// Cinemeta responds with an empty meta object for some unknown IDs
+		c.metrics.error("incomplete")
+		c.setNotFound(imdbID)
+		return Meta{}, fmt.Errorf("%w: Couldn't find %v name in Cinemeta response", ErrMetaNotFound, t)
 	}
 
 	// Fill cache
 	if err = c.cache.Set(imdbID, cineRes.Meta); err != nil {
-		c.logger.Error("Couldn't cache meta", zap.Error(err), zap.String("meta", fmt.Sprintf("%+v", cineRes.Meta)), zapFieldIMDbID)
+		logger.Error("Couldn't cache meta", "error", err, "meta", fmt.Sprintf("%+v", cineRes.Meta))
 	}
 
 	return cineRes.Meta, nil
 }
+
+// setNotFound puts the ID into the negative cache.
+func (c *Client) setNotFound(imdbID string) {
+	if c.negativeTTL <= 0 {
+		return
+	}
+	c.notFoundLock.Lock()
+	defer c.notFoundLock.Unlock()
+	// Expired entries are removed here, so that the map doesn't grow forever with IDs that are requested only once
+	if len(c.notFound) >= maxNotFound {
+		for id, notFoundSince := range c.notFound {
+			if time.Since(notFoundSince) > c.negativeTTL {
+				delete(c.notFound, id)
+			}
+		}
+		if len(c.notFound) >= maxNotFound {
+			return
+		}
+	}
+	c.notFound[imdbID] = time.Now()
+}
+
+// Ping checks whether Cinemeta is reachable by requesting its manifest.
+// It doesn't use the cache, so it's suitable for health checks.
+func (c *Client) Ping(ctx context.Context) error {
+	req, err := c.newRequest(ctx, c.baseURL+"/manifest.json")
+	if err != nil {
+		return err
+	}
+	res, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("Couldn't GET Cinemeta manifest: %v", err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("Bad GET response: %v", res.StatusCode)
+	}
+	return nil
+}
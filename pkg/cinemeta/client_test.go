@@ -0,0 +1,356 @@
+package cinemeta
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/VictoriaMetrics/metrics"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestGetEpisode(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		// Runs in the server's goroutine, where require can't stop the test
+		assert.Equal(t, "/meta/series/tt0944947.json", r.URL.Path)
+		_, _ = w.Write([]byte(`{"meta":{"id":"tt0944947","type":"series","name":"Game of Thrones","videos":[
+			{"id":"tt0944947:1:1","name":"Winter Is Coming","season":1,"episode":1,"number":1},
+			{"id":"tt0944947:2:5","name":"The Ghost of Harrenhal","season":2,"episode":5,"number":5}
+		]}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(ClientOptions{BaseURL: server.URL}, NewInMemoryCache(), logging.NewNopLogger())
+
+	meta, video, err := client.GetEpisode(context.Background(), "tt0944947", 2, 5)
+	require.NoError(t, err)
+	require.Equal(t, "Game of Thrones", meta.Name)
+	require.Equal(t, "The Ghost of Harrenhal", video.EpisodeName())
+
+	// The TV show is cached, so other episodes don't lead to another request
+	meta, _, err = client.GetEpisode(context.Background(), "tt0944947", 3, 1)
+	require.ErrorIs(t, err, ErrEpisodeNotFound)
+	require.Equal(t, "Game of Thrones", meta.Name)
+	require.Equal(t, int32(1), requests.Load())
+}
+
+func TestCoalescingAndNegativeCache(t *testing.T) {
+	var requests int32
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if r.URL.Path == "/meta/movie/tt0.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		<-release
+		_, _ = w.Write([]byte(`{"meta":{"id":"tt0133093","type":"movie","name":"The Matrix"}}`))
+	}))
+	defer server.Close()
+
+	client := NewClient(ClientOptions{BaseURL: server.URL}, NewInMemoryCache(), logging.NewNopLogger())
+
+	// Concurrent calls lead to one request
+	var wg sync.WaitGroup
+	metas := make([]Meta, 10)
+	errs := make([]error, 10)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			metas[i], errs[i] = client.GetMovie(context.Background(), "tt0133093")
+		}(i)
+	}
+	require.Eventually(t, func() bool { return atomic.LoadInt32(&requests) == 1 }, time.Second, time.Millisecond)
+	close(release)
+	wg.Wait()
+	for i := range metas {
+		require.NoError(t, errs[i])
+		require.Equal(t, "The Matrix", metas[i].Name)
+	}
+	require.Equal(t, int32(1), atomic.LoadInt32(&requests))
+
+	// Unknown IDs are only requested once
+	for i := 0; i < 3; i++ {
+		_, err := client.GetMovie(context.Background(), "tt0")
+		require.ErrorIs(t, err, ErrMetaNotFound)
+	}
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+}
+
+func TestClientCopiesIDs(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	client := NewClient(ClientOptions{BaseURL: server.URL}, NewInMemoryCache(), logging.NewNopLogger())
+
+	// Like the strings that point into fasthttp's request buffers, which are reused for the next request
+	buf := []byte("tt1")
+	imdbID := unsafe.String(&buf[0], len(buf))
+	_, err := client.GetMovie(context.Background(), imdbID)
+	require.ErrorIs(t, err, ErrMetaNotFound)
+	copy(buf, "tt2")
+
+	// The negative cache entry is still the one for the original ID
+	_, err = client.GetMovie(context.Background(), "tt1")
+	require.ErrorIs(t, err, ErrMetaNotFound)
+	require.Equal(t, int32(1), requests.Load())
+}
+
+func TestSharedRequestTimeout(t *testing.T) {
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer server.Close()
+	defer close(release)
+
+	// The HTTP client doesn't have a timeout and the context isn't cancelled, so only the shared request's timeout ends it
+	opts := ClientOptions{
+		BaseURL:    server.URL,
+		Timeout:    50 * time.Millisecond,
+		HTTPClient: &http.Client{},
+	}
+	client := NewClient(opts, NewInMemoryCache(), logging.NewNopLogger())
+	start := time.Now()
+	_, err := client.GetMovie(context.Background(), "tt0133093")
+	require.ErrorContains(t, err, "context deadline exceeded")
+	require.Less(t, time.Since(start), time.Second)
+}
+
+func TestRetriesCircuitBreakerAndStaleOnError(t *testing.T) {
+	var requests int32
+	var failing atomic.Bool
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		count := atomic.AddInt32(&requests, 1)
+		// The first request of each pair fails while Cinemeta is "up"
+		if failing.Load() || count%2 == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"meta":{"id":"tt0133093","type":"movie","name":"The Matrix"}}`))
+	}))
+	defer server.Close()
+
+	opts := ClientOptions{
+		BaseURL:                 server.URL,
+		TTL:                     time.Nanosecond,
+		Retries:                 1,
+		RetryBackoff:            time.Millisecond,
+		CircuitBreakerThreshold: 2,
+		CircuitBreakerCooldown:  time.Hour,
+		ServeStaleOnError:       true,
+	}
+	client := NewClient(opts, NewInMemoryCache(), logging.NewNopLogger())
+
+	// The transient error is retried
+	meta, err := client.GetMovie(context.Background(), "tt0133093")
+	require.NoError(t, err)
+	require.Equal(t, "The Matrix", meta.Name)
+	require.Equal(t, int32(2), atomic.LoadInt32(&requests))
+
+	// While Cinemeta is down, the expired entry is returned
+	failing.Store(true)
+	for i := 0; i < 2; i++ {
+		meta, err = client.GetMovie(context.Background(), "tt0133093")
+		require.NoError(t, err)
+		require.Equal(t, "The Matrix", meta.Name)
+	}
+	require.Equal(t, int32(6), atomic.LoadInt32(&requests))
+
+	// The circuit is open now, so Cinemeta isn't requested anymore
+	_, err = client.GetMovie(context.Background(), "tt0120737")
+	require.ErrorIs(t, err, ErrCircuitOpen)
+	require.Equal(t, int32(6), atomic.LoadInt32(&requests))
+}
+
+func TestStaleOnErrorWithDefaultCaches(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	// Expired according to the client's default TTL, but within the default max stale age
+	expired := time.Now().Add(-DefaultClientOpts.TTL - 24*time.Hour)
+	// Older than the max stale age
+	tooOld := time.Now().Add(-DefaultClientOpts.TTL - DefaultClientOpts.MaxStaleAge - time.Hour)
+
+	lruCache, err := NewLRUCache(LRUCacheOptions{})
+	require.NoError(t, err)
+	defer lruCache.Close()
+	require.NoError(t, lruCache.Set("tt0133093", Meta{Name: "The Matrix"}))
+	require.NoError(t, lruCache.Set("tt0234215", Meta{Name: "The Matrix Reloaded"}))
+	lruCache.items["tt0133093"].Value.(*lruCacheEntry[Meta]).created = expired
+	lruCache.items["tt0234215"].Value.(*lruCacheEntry[Meta]).created = tooOld
+	// The background cleanup keeps the expired entry
+	lruCache.removeExpired()
+
+	path := filepath.Join(t.TempDir(), "cinemeta.jsonl")
+	var records bytes.Buffer
+	enc := json.NewEncoder(&records)
+	require.NoError(t, enc.Encode(fileCacheRecord{Key: "tt0133093", Meta: Meta{Name: "The Matrix"}, Created: expired}))
+	require.NoError(t, enc.Encode(fileCacheRecord{Key: "tt0234215", Meta: Meta{Name: "The Matrix Reloaded"}, Created: tooOld}))
+	require.NoError(t, os.WriteFile(path, records.Bytes(), 0o644))
+	// Loading the file keeps the expired entry
+	fileCache, err := NewFileCache(path, FileCacheOptions{})
+	require.NoError(t, err)
+	defer fileCache.Close()
+
+	for name, cache := range map[string]Cache{"lru": lruCache, "file": fileCache} {
+		t.Run(name, func(t *testing.T) {
+			client := NewClient(ClientOptions{BaseURL: server.URL, ServeStaleOnError: true}, cache, logging.NewNopLogger())
+			meta, err := client.GetMovie(context.Background(), "tt0133093")
+			require.NoError(t, err)
+			require.Equal(t, "The Matrix", meta.Name)
+
+			_, err = client.GetMovie(context.Background(), "tt0234215")
+			require.ErrorContains(t, err, "503")
+		})
+	}
+}
+
+func TestGetCatalogAndSearch(t *testing.T) {
+	var paths []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		paths = append(paths, r.URL.EscapedPath())
+		_, _ = w.Write([]byte(`{"metas":[{"id":"tt0133093","imdb_id":"tt0133093","type":"movie","name":"The Matrix","releaseInfo":"1999"}]}`))
+	}))
+	defer server.Close()
+
+	previewCache, err := NewLRUPreviewCache(LRUCacheOptions{})
+	require.NoError(t, err)
+	defer previewCache.Close()
+	opts := ClientOptions{
+		BaseURL:      server.URL,
+		PreviewCache: previewCache,
+	}
+	client := NewClient(opts, NewInMemoryCache(), logging.NewNopLogger())
+
+	previews, err := client.GetCatalog(context.Background(), "movie", CatalogTop, CatalogExtra{Genre: "Sci-Fi", Skip: 100})
+	require.NoError(t, err)
+	require.Len(t, previews, 1)
+	require.Equal(t, "The Matrix", previews[0].Name)
+	require.Equal(t, "1999", previews[0].ReleaseInfo)
+
+	previews, err = client.Search(context.Background(), "movie", "the matrix")
+	require.NoError(t, err)
+	require.Equal(t, "tt0133093", previews[0].IMDbID)
+
+	// Cached
+	_, err = client.Search(context.Background(), "movie", "the matrix")
+	require.NoError(t, err)
+	require.Equal(t, []string{
+		"/catalog/movie/top/genre=Sci-Fi&skip=100.json",
+		"/catalog/movie/top/search=the%20matrix.json",
+	}, paths)
+
+	_, err = client.GetCatalog(context.Background(), "channel", CatalogTop, CatalogExtra{})
+	require.Error(t, err)
+}
+
+type roundTripperFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
+func TestTransportAndHeaders(t *testing.T) {
+	var reqs []*http.Request
+	transport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		reqs = append(reqs, req)
+		return &http.Response{
+			StatusCode: http.StatusOK,
+			Body:       io.NopCloser(strings.NewReader(`{"meta":{"id":"tt0133093","type":"movie","name":"The Matrix"}}`)),
+			Request:    req,
+		}, nil
+	})
+	opts := ClientOptions{
+		BaseURL:   "https://cinemeta.example.com",
+		Transport: transport,
+		Headers:   http.Header{"authorization": []string{"Bearer secret"}},
+		UserAgent: "my-addon/1.0",
+	}
+	client := NewClient(opts, NewInMemoryCache(), logging.NewNopLogger())
+
+	meta, err := client.GetMovie(context.Background(), "tt0133093")
+	require.NoError(t, err)
+	require.Equal(t, "The Matrix", meta.Name)
+	require.NoError(t, client.Ping(context.Background()))
+
+	require.Len(t, reqs, 2)
+	for _, req := range reqs {
+		require.Equal(t, "cinemeta.example.com", req.URL.Host)
+		require.Equal(t, "Bearer secret", req.Header.Get("Authorization"))
+		require.Equal(t, "my-addon/1.0", req.UserAgent())
+	}
+}
+
+// scrapeMetric returns the value of the time series from the Prometheus exposition of the default metrics set, or 0 if it doesn't exist.
+func scrapeMetric(t *testing.T, series string) float64 {
+	buf := &bytes.Buffer{}
+	metrics.WritePrometheus(buf, false)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if value, ok := strings.CutPrefix(line, series+" "); ok {
+			f, err := strconv.ParseFloat(value, 64)
+			require.NoError(t, err)
+			return f
+		}
+	}
+	return 0
+}
+
+func TestMetrics(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/meta/movie/tt0.json" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		_, _ = w.Write([]byte(`{"meta":{"id":"tt0133093","type":"movie","name":"The Matrix"}}`))
+	}))
+	defer server.Close()
+	client := NewClient(ClientOptions{BaseURL: server.URL, Metrics: true}, NewInMemoryCache(), logging.NewNopLogger())
+
+	series := []string{
+		`cinemeta_requests_total{type="movie"}`,
+		`cinemeta_request_duration_seconds_count`,
+		`cinemeta_cache_lookups_total{result="miss"}`,
+		`cinemeta_cache_lookups_total{result="hit"}`,
+		`cinemeta_cache_lookups_total{result="negative"}`,
+		`cinemeta_errors_total{kind="status"}`,
+	}
+	before := map[string]float64{}
+	for _, s := range series {
+		before[s] = scrapeMetric(t, s)
+	}
+
+	for _, imdbID := range []string{"tt0133093", "tt0133093", "tt0", "tt0"} {
+		_, _ = client.GetMovie(context.Background(), imdbID)
+	}
+
+	// Two requests, one of them 404. The negative cache is only checked after a cache miss.
+	expected := []float64{2, 2, 3, 1, 1, 1}
+	for i, s := range series {
+		require.Equal(t, expected[i], scrapeMetric(t, s)-before[s], s)
+	}
+}
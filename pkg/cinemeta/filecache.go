@@ -0,0 +1,358 @@
+package cinemeta
+
+import (
+	"bufio"
+	"container/list"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FileCacheOptions are the options for the FileCache.
+type FileCacheOptions struct {
+	// Max number of entries. When it's reached, the least recently written entry is evicted.
+	// Evicted entries remain in the file until it's compacted, but they don't take up memory.
+	// Default 10,000.
+	MaxEntries int
+	// Max age of entries. Older entries are skipped when loading the file and removed when compacting it.
+	// It should be at least as long as the client's TTL, because the client decides itself whether an entry is expired,
+	// plus the client's MaxStaleAge when it serves stale entries on errors.
+	// Default 37 days, the client's default TTL plus its default MaxStaleAge.
+	TTL time.Duration
+	// The file is compacted when it contains more than this factor times as many records as there are entries,
+	// for example due to entries being overwritten.
+	// Default 2.
+	CompactionRatio float64
+	// Min number of records in the file before it's compacted, so that small files aren't compacted all the time.
+	// Default 1000.
+	MinCompactionRecords int
+	// Flag for indicating whether to sync the file to disk after each write.
+	// Without it, the latest entries can get lost when the machine (not just the process) crashes.
+	// Default false.
+	SyncWrites bool
+}
+
+// DefaultFileCacheOpts is an options object with sensible defaults.
+var DefaultFileCacheOpts = FileCacheOptions{
+	MaxEntries:           10000,
+	TTL:                  DefaultClientOpts.TTL + DefaultClientOpts.MaxStaleAge,
+	CompactionRatio:      2,
+	MinCompactionRecords: 1000,
+}
+
+var _ Cache = (*FileCache)(nil)
+
+// FileCache is an implementation of the Cache interface that persists its entries in a file, so they survive restarts.
+// The file is an append-only log with one JSON record per line, which is compacted automatically when it contains too many overwritten records.
+// All entries are loaded into memory when the cache is created, so reads don't touch the disk.
+// The number of entries is bounded by MaxEntries, so the memory usage is bounded as well.
+// It's safe for concurrent use within one process, but the file must not be used by multiple processes at the same time.
+// Call Close() when you don't need the cache anymore.
+type FileCache struct {
+	opts  FileCacheOptions
+	path  string
+	lock  *sync.RWMutex
+	items map[string]*list.Element
+	// Least recently written at the front
+	order   *list.List
+	file    *os.File
+	records int
+	closed  bool
+	// Only one compaction runs at a time
+	compactLock *sync.Mutex
+	// Records that are written while a compaction runs outside of the lock.
+	// They're appended to the compacted file before it replaces the current one.
+	// Nil when no compaction is running.
+	pending [][]byte
+}
+
+type fileCacheEntry struct {
+	key  string
+	item CacheItem
+}
+
+type fileCacheRecord struct {
+	Key     string    `json:"key"`
+	Meta    Meta      `json:"meta"`
+	Created time.Time `json:"created"`
+}
+
+// NewFileCache creates a new FileCache that's backed by the file at the given path.
+// If the file exists, its entries are loaded into memory. Otherwise it's created.
+// An incomplete last record, for example due to a crash while writing it, is ignored.
+func NewFileCache(path string, opts FileCacheOptions) (*FileCache, error) {
+	// Set defaults if necessary
+	if opts.TTL == 0 {
+		opts.TTL = DefaultFileCacheOpts.TTL
+	}
+	if opts.CompactionRatio == 0 {
+		opts.CompactionRatio = DefaultFileCacheOpts.CompactionRatio
+	} else if opts.CompactionRatio < 1 {
+		return nil, errors.New("Compaction ratio must be at least 1")
+	}
+	if opts.MaxEntries == 0 {
+		opts.MaxEntries = DefaultFileCacheOpts.MaxEntries
+	} else if opts.MaxEntries < 0 {
+		return nil, errors.New("Max entries must not be negative")
+	}
+	if opts.MinCompactionRecords == 0 {
+		opts.MinCompactionRecords = DefaultFileCacheOpts.MinCompactionRecords
+	}
+
+	c := &FileCache{
+		opts:        opts,
+		path:        path,
+		lock:        &sync.RWMutex{},
+		items:       map[string]*list.Element{},
+		order:       list.New(),
+		compactLock: &sync.Mutex{},
+	}
+	if err := c.load(); err != nil {
+		return nil, err
+	}
+	// Rewriting the file also gets rid of an incomplete last record, which would otherwise corrupt the next appended one
+	if err := c.compact(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// load reads the entries from the file, if it exists.
+func (c *FileCache) load() error {
+	f, err := os.Open(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("Couldn't open cache file: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	// Metas of TV shows with many episodes can be large
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for scanner.Scan() {
+		var record fileCacheRecord
+		if err := json.Unmarshal(scanner.Bytes(), &record); err != nil {
+			// Most likely an incomplete last record
+			continue
+		}
+		if time.Since(record.Created) > c.opts.TTL {
+			continue
+		}
+		c.setItem(record.Key, CacheItem{
+			Meta:    record.Meta,
+			Created: record.Created,
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Couldn't read cache file: %w", err)
+	}
+	return nil
+}
+
+// Set stores a meta object and the current time in the cache and appends it to the file.
+// If this exceeds the max number of entries, the least recently written entry is evicted.
+func (c *FileCache) Set(key string, meta Meta) error {
+	record := fileCacheRecord{
+		Key:     key,
+		Meta:    meta,
+		Created: time.Now(),
+	}
+	line, err := json.Marshal(record)
+	if err != nil {
+		return fmt.Errorf("Couldn't encode cache record: %w", err)
+	}
+	line = append(line, '\n')
+
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return errors.New("Cache is closed")
+	}
+	if _, err := c.file.Write(line); err != nil {
+		c.lock.Unlock()
+		return fmt.Errorf("Couldn't write to cache file: %w", err)
+	}
+	if c.opts.SyncWrites {
+		if err := c.file.Sync(); err != nil {
+			c.lock.Unlock()
+			return fmt.Errorf("Couldn't sync cache file: %w", err)
+		}
+	}
+	if c.pending != nil {
+		c.pending = append(c.pending, line)
+	}
+	c.records++
+	c.setItem(key, CacheItem{
+		Meta:    meta,
+		Created: record.Created,
+	})
+	needsCompaction := c.records >= c.opts.MinCompactionRecords && float64(c.records) > c.opts.CompactionRatio*float64(len(c.items))
+	c.lock.Unlock()
+
+	// Only compact if no other compaction is running, which already takes care of it
+	if needsCompaction && c.compactLock.TryLock() {
+		defer c.compactLock.Unlock()
+		if err := c.compact(); err != nil {
+			return fmt.Errorf("Couldn't compact cache file: %w", err)
+		}
+	}
+	return nil
+}
+
+// setItem stores the item and evicts the least recently written entry if necessary. The lock must be held.
+func (c *FileCache) setItem(key string, item CacheItem) {
+	if elem, ok := c.items[key]; ok {
+		elem.Value.(*fileCacheEntry).item = item
+		c.order.MoveToBack(elem)
+		return
+	}
+	// The key is kept after Set returns, so it mustn't share memory with a string that the caller reuses, like a request buffer
+	key = strings.Clone(key)
+	c.items[key] = c.order.PushBack(&fileCacheEntry{key: key, item: item})
+	for len(c.items) > c.opts.MaxEntries {
+		c.removeElement(c.order.Front())
+	}
+}
+
+// removeElement removes the element from the list and map. The lock must be held.
+func (c *FileCache) removeElement(elem *list.Element) {
+	c.order.Remove(elem)
+	delete(c.items, elem.Value.(*fileCacheEntry).key)
+}
+
+// Get returns a meta object and the time it was cached from the cache.
+// The boolean return value signals if the value was found in the cache.
+func (c *FileCache) Get(key string) (Meta, time.Time, bool, error) {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	elem, found := c.items[key]
+	if !found {
+		return Meta{}, time.Time{}, false, nil
+	}
+	cacheItem := elem.Value.(*fileCacheEntry).item
+	return cacheItem.Meta, cacheItem.Created, true, nil
+}
+
+// Len returns the number of entries in the cache.
+func (c *FileCache) Len() int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	return len(c.items)
+}
+
+// Compact rewrites the file with only the current entries, removing overwritten, evicted and expired ones.
+// It's called automatically, but can be called manually, for example before a backup.
+// The file is written without blocking reads and writes of the cache.
+func (c *FileCache) Compact() error {
+	c.compactLock.Lock()
+	defer c.compactLock.Unlock()
+	return c.compact()
+}
+
+// compact writes the current entries to a temporary file and atomically replaces the cache file with it.
+// Only the snapshot of the entries and the replacement of the file happen while holding the lock.
+// The compactLock must be held.
+func (c *FileCache) compact() error {
+	// Snapshot the entries, oldest first so that the order survives restarts
+	c.lock.Lock()
+	if c.closed {
+		c.lock.Unlock()
+		return errors.New("Cache is closed")
+	}
+	records := make([]fileCacheRecord, 0, len(c.items))
+	for elem := c.order.Front(); elem != nil; {
+		next := elem.Next()
+		entry := elem.Value.(*fileCacheEntry)
+		if time.Since(entry.item.Created) > c.opts.TTL {
+			c.removeElement(elem)
+		} else {
+			records = append(records, fileCacheRecord{Key: entry.key, Meta: entry.item.Meta, Created: entry.item.Created})
+		}
+		elem = next
+	}
+	c.pending = [][]byte{}
+	c.lock.Unlock()
+
+	tmpPath := c.path + ".tmp"
+	tmp, err := c.writeTempFile(tmpPath, records)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	pending := c.pending
+	c.pending = nil
+	if err != nil {
+		return err
+	} else if c.closed {
+		tmp.Close()
+		_ = os.Remove(tmpPath)
+		return errors.New("Cache is closed")
+	}
+	// Records that were written in the meantime
+	for _, line := range pending {
+		if _, err := tmp.Write(line); err != nil {
+			tmp.Close()
+			return fmt.Errorf("Couldn't write temporary cache file: %w", err)
+		}
+	}
+	if len(pending) > 0 {
+		if err := tmp.Sync(); err != nil {
+			tmp.Close()
+			return fmt.Errorf("Couldn't sync temporary cache file: %w", err)
+		}
+	}
+	if err := os.Rename(tmpPath, c.path); err != nil {
+		tmp.Close()
+		return fmt.Errorf("Couldn't replace cache file: %w", err)
+	}
+
+	// Keep the handle of the temporary file for appending, as the old handle points to the replaced file
+	if c.file != nil {
+		c.file.Close()
+	}
+	c.file = tmp
+	c.records = len(records) + len(pending)
+	return nil
+}
+
+// writeTempFile writes the records to a new temporary file and returns it, opened for appending.
+func (c *FileCache) writeTempFile(tmpPath string, records []fileCacheRecord) (*os.File, error) {
+	tmp, err := os.OpenFile(tmpPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't create temporary cache file: %w", err)
+	}
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, record := range records {
+		if err := enc.Encode(record); err != nil {
+			tmp.Close()
+			return nil, fmt.Errorf("Couldn't write temporary cache file: %w", err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("Couldn't write temporary cache file: %w", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("Couldn't sync temporary cache file: %w", err)
+	}
+	return tmp, nil
+}
+
+// Close closes the file. The cache can't be used anymore afterwards.
+func (c *FileCache) Close() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	err := c.file.Close()
+	c.file = nil
+	return err
+}
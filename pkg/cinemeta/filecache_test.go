@@ -0,0 +1,148 @@
+package cinemeta
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"testing"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cinemeta.jsonl")
+
+	cache, err := NewFileCache(path, FileCacheOptions{MinCompactionRecords: 4})
+	require.NoError(t, err)
+	require.NoError(t, cache.Set("tt1", Meta{Name: "1"}))
+	require.NoError(t, cache.Set("tt2", Meta{Name: "2"}))
+	// Overwriting leads to a compaction when there are more than 2 records per entry
+	require.NoError(t, cache.Set("tt1", Meta{Name: "1b"}))
+	require.NoError(t, cache.Set("tt1", Meta{Name: "1c"}))
+	require.Equal(t, 4, cache.records)
+	require.NoError(t, cache.Set("tt1", Meta{Name: "1d"}))
+	require.Equal(t, 2, cache.records)
+	require.NoError(t, cache.Close())
+
+	// Simulate a crash while writing a record
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0o644)
+	require.NoError(t, err)
+	_, err = f.WriteString(`{"key":"tt3","meta":{"na`)
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	// The entries survive a restart
+	cache, err = NewFileCache(path, FileCacheOptions{})
+	require.NoError(t, err)
+	defer cache.Close()
+	require.Equal(t, 2, cache.Len())
+	meta, _, found, err := cache.Get("tt1")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "1d", meta.Name)
+
+	// The incomplete record was removed, so new records are readable
+	require.NoError(t, cache.Set("tt3", Meta{Name: "3"}))
+	require.NoError(t, cache.Close())
+	cache, err = NewFileCache(path, FileCacheOptions{})
+	require.NoError(t, err)
+	defer cache.Close()
+	require.Equal(t, 3, cache.Len())
+}
+
+func TestFileCacheMaxEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cinemeta.jsonl")
+
+	cache, err := NewFileCache(path, FileCacheOptions{MaxEntries: 2})
+	require.NoError(t, err)
+	require.NoError(t, cache.Set("tt1", Meta{Name: "1"}))
+	require.NoError(t, cache.Set("tt2", Meta{Name: "2"}))
+	// Overwriting makes tt1 the most recently written entry
+	require.NoError(t, cache.Set("tt1", Meta{Name: "1b"}))
+	require.NoError(t, cache.Set("tt3", Meta{Name: "3"}))
+	require.Equal(t, 2, cache.Len())
+	_, _, found, err := cache.Get("tt2")
+	require.NoError(t, err)
+	require.False(t, found)
+	require.NoError(t, cache.Close())
+
+	// The evicted entry is still in the file, but the limit also applies when loading it
+	cache, err = NewFileCache(path, FileCacheOptions{MaxEntries: 2})
+	require.NoError(t, err)
+	defer cache.Close()
+	require.Equal(t, 2, cache.Len())
+	meta, _, found, err := cache.Get("tt1")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "1b", meta.Name)
+	_, _, found, err = cache.Get("tt2")
+	require.NoError(t, err)
+	require.False(t, found)
+
+	_, err = NewFileCache(path, FileCacheOptions{MaxEntries: -1})
+	require.Error(t, err)
+}
+
+func TestFileCacheConcurrentCompaction(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cinemeta.jsonl")
+
+	cache, err := NewFileCache(path, FileCacheOptions{MinCompactionRecords: 10})
+	require.NoError(t, err)
+
+	// Records that are written during a compaction mustn't get lost
+	var wg sync.WaitGroup
+	errs := make(chan error, 1000)
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				errs <- cache.Set("tt"+strconv.Itoa(i), Meta{Name: strconv.Itoa(j)})
+				if j%10 == 0 {
+					errs <- cache.Compact()
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		require.NoError(t, err)
+	}
+	require.NoError(t, cache.Close())
+	require.Error(t, cache.Set("tt0", Meta{}))
+	require.Error(t, cache.Compact())
+
+	cache, err = NewFileCache(path, FileCacheOptions{})
+	require.NoError(t, err)
+	defer cache.Close()
+	require.Equal(t, 10, cache.Len())
+	for i := 0; i < 10; i++ {
+		meta, _, found, err := cache.Get("tt" + strconv.Itoa(i))
+		require.NoError(t, err)
+		require.True(t, found)
+		require.Equal(t, "49", meta.Name)
+	}
+}
+
+func TestFileCacheKeyCopy(t *testing.T) {
+	cache, err := NewFileCache(filepath.Join(t.TempDir(), "cinemeta.jsonl"), FileCacheOptions{})
+	require.NoError(t, err)
+	defer cache.Close()
+
+	// Like the strings that point into fasthttp's request buffers, which are reused for the next request
+	buf := []byte("tt1")
+	key := unsafe.String(&buf[0], len(buf))
+	require.NoError(t, cache.Set(key, Meta{Name: "1"}))
+	copy(buf, "tt2")
+
+	meta, _, found, err := cache.Get("tt1")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "1", meta.Name)
+	_, _, found, err = cache.Get("tt2")
+	require.NoError(t, err)
+	require.False(t, found)
+}
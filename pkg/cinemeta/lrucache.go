@@ -0,0 +1,267 @@
+package cinemeta
+
+import (
+	"container/list"
+	"encoding/json"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LRUCacheOptions are the options for the LRUCache.
+type LRUCacheOptions struct {
+	// Max number of entries. When it's reached, the least recently used entry is evicted.
+	// Default 10,000.
+	MaxEntries int
+	// Max total size of the entries in bytes, measured as the size of their JSON encoding.
+	// When it's reached, the least recently used entries are evicted.
+	// Default 0, meaning no limit.
+	MaxBytes int64
+	// Max age of entries. Older entries are removed by the background cleanup.
+	// It should be at least as long as the client's TTL, because the client decides itself whether an entry is expired,
+	// plus the client's MaxStaleAge when it serves stale entries on errors.
+	// Default 37 days, the client's default TTL plus its default MaxStaleAge.
+	TTL time.Duration
+	// Interval of the background cleanup that removes entries that are older than the TTL.
+	// Default 1 hour.
+	CleanupInterval time.Duration
+}
+
+// DefaultLRUCacheOpts is an options object with sensible defaults.
+var DefaultLRUCacheOpts = LRUCacheOptions{
+	MaxEntries:      10000,
+	TTL:             DefaultClientOpts.TTL + DefaultClientOpts.MaxStaleAge,
+	CleanupInterval: time.Hour,
+}
+
+// DefaultLRUPreviewCacheOpts is an options object with sensible defaults for the LRUPreviewCache.
+// The TTL is the client's default CatalogTTL plus its default MaxStaleAge.
+var DefaultLRUPreviewCacheOpts = LRUCacheOptions{
+	MaxEntries:      1000,
+	TTL:             DefaultClientOpts.CatalogTTL + DefaultClientOpts.MaxStaleAge,
+	CleanupInterval: time.Hour,
+}
+
+// CacheStats are statistics about the usage of a cache.
+type CacheStats struct {
+	Hits   uint64
+	Misses uint64
+	// Entries that were removed because the max number of entries or bytes was reached
+	Evictions uint64
+	// Entries that were removed because they were older than the TTL
+	Expirations uint64
+	// Current number of entries
+	Entries int
+	// Current size of the entries in bytes
+	Bytes int64
+}
+
+var _ Cache = (*LRUCache)(nil)
+
+// LRUCache is a bounded in-memory implementation of the Cache interface.
+// It evicts the least recently used entries when the max number of entries or bytes is reached,
+// and removes entries that are older than the TTL in the background.
+// Call Close() when you don't need the cache anymore, to stop the background cleanup.
+type LRUCache struct {
+	*lruStore[Meta]
+}
+
+// NewLRUCache creates a new LRUCache and starts its background cleanup.
+func NewLRUCache(opts LRUCacheOptions) (*LRUCache, error) {
+	store, err := newLRUStore[Meta](opts, DefaultLRUCacheOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &LRUCache{store}, nil
+}
+
+// Set stores a meta object and the current time in the cache.
+// If this exceeds the max number of entries or bytes, the least recently used entries are evicted.
+func (c *LRUCache) Set(key string, meta Meta) error {
+	return c.set(key, meta)
+}
+
+// Get returns a meta object and the time it was cached from the cache.
+// The boolean return value signals if the value was found in the cache.
+func (c *LRUCache) Get(key string) (Meta, time.Time, bool, error) {
+	meta, created, found := c.get(key)
+	return meta, created, found, nil
+}
+
+var _ PreviewCache = (*LRUPreviewCache)(nil)
+
+// LRUPreviewCache is a bounded in-memory implementation of the PreviewCache interface, which works like the LRUCache.
+// Bounding it is important, because search queries are arbitrary.
+// Call Close() when you don't need the cache anymore, to stop the background cleanup.
+type LRUPreviewCache struct {
+	*lruStore[[]MetaPreview]
+}
+
+// NewLRUPreviewCache creates a new LRUPreviewCache and starts its background cleanup.
+// Unset options are taken from DefaultLRUPreviewCacheOpts.
+func NewLRUPreviewCache(opts LRUCacheOptions) (*LRUPreviewCache, error) {
+	store, err := newLRUStore[[]MetaPreview](opts, DefaultLRUPreviewCacheOpts)
+	if err != nil {
+		return nil, err
+	}
+	return &LRUPreviewCache{store}, nil
+}
+
+// Set stores the previews and the current time in the cache.
+// If this exceeds the max number of entries or bytes, the least recently used entries are evicted.
+func (c *LRUPreviewCache) Set(key string, previews []MetaPreview) error {
+	return c.set(key, previews)
+}
+
+// Get returns the previews and the time they were cached from the cache.
+// The boolean return value signals if the value was found in the cache.
+func (c *LRUPreviewCache) Get(key string) ([]MetaPreview, time.Time, bool, error) {
+	previews, created, found := c.get(key)
+	return previews, created, found, nil
+}
+
+// lruStore is the implementation of the LRUCache and LRUPreviewCache.
+type lruStore[V any] struct {
+	opts  LRUCacheOptions
+	lock  *sync.Mutex
+	items map[string]*list.Element
+	// Most recently used at the front
+	order *list.List
+	stats CacheStats
+	stop  chan struct{}
+	once  *sync.Once
+}
+
+type lruCacheEntry[V any] struct {
+	key     string
+	value   V
+	created time.Time
+	size    int64
+}
+
+// newLRUStore creates a new lruStore and starts its background cleanup.
+// Unset options are taken from the defaults.
+func newLRUStore[V any](opts LRUCacheOptions, defaults LRUCacheOptions) (*lruStore[V], error) {
+	// Set defaults if necessary
+	if opts.MaxEntries == 0 {
+		opts.MaxEntries = defaults.MaxEntries
+	} else if opts.MaxEntries < 0 {
+		return nil, errors.New("Max entries must not be negative")
+	}
+	if opts.MaxBytes < 0 {
+		return nil, errors.New("Max bytes must not be negative")
+	}
+	if opts.TTL == 0 {
+		opts.TTL = defaults.TTL
+	}
+	if opts.CleanupInterval == 0 {
+		opts.CleanupInterval = defaults.CleanupInterval
+	}
+
+	c := &lruStore[V]{
+		opts:  opts,
+		lock:  &sync.Mutex{},
+		items: map[string]*list.Element{},
+		order: list.New(),
+		stop:  make(chan struct{}),
+		once:  &sync.Once{},
+	}
+	go c.cleanupLoop()
+	return c, nil
+}
+
+func (c *lruStore[V]) set(key string, value V) error {
+	var size int64
+	if c.opts.MaxBytes > 0 {
+		valueJSON, err := json.Marshal(value)
+		if err != nil {
+			return err
+		}
+		size = int64(len(valueJSON))
+	}
+	entry := &lruCacheEntry[V]{
+		// The key is kept after Set returns, so it mustn't share memory with a string that the caller reuses, like a request buffer
+		key:     strings.Clone(key),
+		value:   value,
+		created: time.Now(),
+		size:    size,
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if elem, ok := c.items[key]; ok {
+		c.removeElement(elem)
+	}
+	c.items[entry.key] = c.order.PushFront(entry)
+	c.stats.Bytes += size
+	for len(c.items) > c.opts.MaxEntries || (c.opts.MaxBytes > 0 && c.stats.Bytes > c.opts.MaxBytes && len(c.items) > 1) {
+		c.removeElement(c.order.Back())
+		c.stats.Evictions++
+	}
+	return nil
+}
+
+func (c *lruStore[V]) get(key string) (V, time.Time, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	elem, ok := c.items[key]
+	if !ok {
+		c.stats.Misses++
+		var zero V
+		return zero, time.Time{}, false
+	}
+	c.stats.Hits++
+	c.order.MoveToFront(elem)
+	entry := elem.Value.(*lruCacheEntry[V])
+	return entry.value, entry.created, true
+}
+
+// Stats returns the statistics of the cache.
+func (c *lruStore[V]) Stats() CacheStats {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	stats := c.stats
+	stats.Entries = len(c.items)
+	return stats
+}
+
+// Close stops the background cleanup. The cache can still be used afterwards, but old entries aren't removed anymore.
+func (c *lruStore[V]) Close() {
+	c.once.Do(func() {
+		close(c.stop)
+	})
+}
+
+func (c *lruStore[V]) cleanupLoop() {
+	ticker := time.NewTicker(c.opts.CleanupInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			c.removeExpired()
+		case <-c.stop:
+			return
+		}
+	}
+}
+
+// removeExpired removes all entries that are older than the TTL.
+func (c *lruStore[V]) removeExpired() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	for _, elem := range c.items {
+		if time.Since(elem.Value.(*lruCacheEntry[V]).created) > c.opts.TTL {
+			c.removeElement(elem)
+			c.stats.Expirations++
+		}
+	}
+}
+
+// removeElement removes the element from the list and map. The lock must be held.
+func (c *lruStore[V]) removeElement(elem *list.Element) {
+	entry := elem.Value.(*lruCacheEntry[V])
+	c.order.Remove(elem)
+	delete(c.items, entry.key)
+	c.stats.Bytes -= entry.size
+}
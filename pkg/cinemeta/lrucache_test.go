@@ -0,0 +1,105 @@
+package cinemeta
+
+import (
+	"testing"
+	"time"
+	"unsafe"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestLRUCache(t *testing.T) {
+	cache, err := NewLRUCache(LRUCacheOptions{MaxEntries: 2, TTL: 50 * time.Millisecond, CleanupInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	defer cache.Close()
+
+	require.NoError(t, cache.Set("tt1", Meta{Name: "1"}))
+	require.NoError(t, cache.Set("tt2", Meta{Name: "2"}))
+	// Makes tt1 the most recently used one
+	meta, _, found, err := cache.Get("tt1")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "1", meta.Name)
+	// Evicts tt2
+	require.NoError(t, cache.Set("tt3", Meta{Name: "3"}))
+	_, _, found, _ = cache.Get("tt2")
+	require.False(t, found)
+
+	stats := cache.Stats()
+	require.Equal(t, uint64(1), stats.Hits)
+	require.Equal(t, uint64(1), stats.Misses)
+	require.Equal(t, uint64(1), stats.Evictions)
+	require.Equal(t, 2, stats.Entries)
+
+	// Background cleanup
+	require.Eventually(t, func() bool {
+		return cache.Stats().Entries == 0
+	}, time.Second, 10*time.Millisecond)
+	require.Equal(t, uint64(2), cache.Stats().Expirations)
+}
+
+func TestLRUCacheMaxBytes(t *testing.T) {
+	cache, err := NewLRUCache(LRUCacheOptions{MaxBytes: 50})
+	require.NoError(t, err)
+	defer cache.Close()
+
+	require.NoError(t, cache.Set("tt1", Meta{Name: "1"}))
+	require.NoError(t, cache.Set("tt2", Meta{Name: "2"}))
+	require.NoError(t, cache.Set("tt3", Meta{Name: "3"}))
+	stats := cache.Stats()
+	require.LessOrEqual(t, stats.Bytes, int64(50))
+	require.Greater(t, stats.Evictions, uint64(0))
+}
+
+func TestLRUCacheOptions(t *testing.T) {
+	_, err := NewLRUCache(LRUCacheOptions{MaxEntries: -1})
+	require.Error(t, err)
+	_, err = NewLRUCache(LRUCacheOptions{MaxBytes: -1})
+	require.Error(t, err)
+}
+
+func TestLRUCacheKeyCopy(t *testing.T) {
+	cache, err := NewLRUCache(LRUCacheOptions{MaxEntries: 1})
+	require.NoError(t, err)
+	defer cache.Close()
+
+	// Like the strings that point into fasthttp's request buffers, which are reused for the next request
+	buf := []byte("tt1")
+	key := unsafe.String(&buf[0], len(buf))
+	require.NoError(t, cache.Set(key, Meta{Name: "1"}))
+	copy(buf, "tt2")
+
+	meta, _, found, err := cache.Get("tt1")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "1", meta.Name)
+	_, _, found, err = cache.Get("tt2")
+	require.NoError(t, err)
+	require.False(t, found)
+	// Evicting deletes the right map entry
+	require.NoError(t, cache.Set("tt3", Meta{Name: "3"}))
+	require.Equal(t, 1, cache.Stats().Entries)
+}
+
+func TestLRUPreviewCache(t *testing.T) {
+	cache, err := NewLRUPreviewCache(LRUCacheOptions{MaxEntries: 2})
+	require.NoError(t, err)
+	defer cache.Close()
+
+	// Arbitrary search queries don't grow the cache beyond its limit
+	for _, query := range []string{"a", "b", "c"} {
+		require.NoError(t, cache.Set("/catalog/movie/top/search="+query+".json", []MetaPreview{{Name: query}}))
+	}
+	require.Equal(t, 2, cache.Stats().Entries)
+	_, _, found, err := cache.Get("/catalog/movie/top/search=a.json")
+	require.NoError(t, err)
+	require.False(t, found)
+	previews, created, found, err := cache.Get("/catalog/movie/top/search=c.json")
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, "c", previews[0].Name)
+	require.WithinDuration(t, time.Now(), created, time.Second)
+
+	_, err = NewLRUPreviewCache(LRUCacheOptions{MaxEntries: -1})
+	require.Error(t, err)
+}
@@ -0,0 +1,67 @@
+package cinemeta
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/VictoriaMetrics/metrics"
+)
+
+// clientMetrics are the metrics of a Client.
+// They're registered in the default VictoriaMetrics set, which is the one that go-stremio exposes on its "/metrics" endpoint.
+type clientMetrics struct {
+	requestDuration *metrics.Histogram
+}
+
+func newClientMetrics() *clientMetrics {
+	// Using GetOrCreate instead of New so that creating multiple clients in one process doesn't panic
+	return &clientMetrics{
+		requestDuration: metrics.GetOrCreateHistogram("cinemeta_request_duration_seconds"),
+	}
+}
+
+// request counts a request to Cinemeta and records its duration.
+// The resource type is one of "movie", "series" and "catalog".
+func (m *clientMetrics) request(resourceType string, start time.Time) {
+	if m == nil {
+		return
+	}
+	metrics.GetOrCreateCounter(fmt.Sprintf(`cinemeta_requests_total{type="%v"}`, resourceType)).Inc()
+	m.requestDuration.UpdateDuration(start)
+}
+
+// error counts a failed request to Cinemeta.
+// The kind is one of "request", "status", "read", "decode", "incomplete" and "circuit_open".
+func (m *clientMetrics) error(kind string) {
+	if m == nil {
+		return
+	}
+	metrics.GetOrCreateCounter(fmt.Sprintf(`cinemeta_errors_total{kind="%v"}`, kind)).Inc()
+}
+
+// cache counts a cache lookup.
+// The result is one of "hit", "miss", "expired", "negative" and "error",
+// or "stale" when an expired item is returned because requesting Cinemeta failed.
+func (m *clientMetrics) cache(result string) {
+	if m == nil {
+		return
+	}
+	metrics.GetOrCreateCounter(fmt.Sprintf(`cinemeta_cache_lookups_total{result="%v"}`, result)).Inc()
+}
+
+// catalogCache counts a preview cache lookup for a catalog or search.
+// The result is one of "hit", "miss", "expired", "error" and "stale".
+func (m *clientMetrics) catalogCache(result string) {
+	if m == nil {
+		return
+	}
+	metrics.GetOrCreateCounter(fmt.Sprintf(`cinemeta_catalog_cache_lookups_total{result="%v"}`, result)).Inc()
+}
+
+// coalesced counts a call that didn't lead to its own request to Cinemeta, because a request for the same ID was already in flight.
+func (m *clientMetrics) coalesced() {
+	if m == nil {
+		return
+	}
+	metrics.GetOrCreateCounter("cinemeta_coalesced_requests_total").Inc()
+}
@@ -13,6 +13,11 @@ func (mt mediaType) String() string {
 	return [...]string{"movie", "TV show"}[mt-1]
 }
 
+// resourceType returns the type that Cinemeta uses in its URLs.
+func (mt mediaType) resourceType() string {
+	return [...]string{"movie", "series"}[mt-1]
+}
+
 type cinemetaResponse struct {
 	Meta Meta `json:"meta"`
 }
@@ -73,3 +78,50 @@ type Meta struct {
 	Website        string          `json:"website,omitempty"`
 	Videos         []Video         `json:"videos,omitempty"`
 }
+
+// MetaPreview represents a movie or TV show in a catalog or search result.
+// It has fewer fields than Meta. Use GetMovie or GetTVShow with its ID for getting the full meta.
+type MetaPreview struct {
+	ID   string `json:"id"`
+	Type string `json:"type"`
+	Name string `json:"name"`
+
+	// Optional
+	IMDbID      string   `json:"imdb_id,omitempty"`
+	Genres      []string `json:"genres,omitempty"`
+	Poster      string   `json:"poster,omitempty"`
+	Background  string   `json:"background,omitempty"`
+	Logo        string   `json:"logo,omitempty"`
+	Description string   `json:"description,omitempty"`
+	ReleaseInfo string   `json:"releaseInfo,omitempty"` // A.k.a. *year*. E.g. "2000" for movies and "2000-2014" or "2000-" for TV shows
+	IMDbRating  string   `json:"imdbRating,omitempty"`
+	Runtime     string   `json:"runtime,omitempty"`
+}
+
+// FindEpisode returns the video of the TV show's episode with the given season and episode number.
+func (m Meta) FindEpisode(season, episode int) (Video, bool) {
+	for _, video := range m.Videos {
+		if video.Season == season && video.EpisodeNumber() == episode {
+			return video, true
+		}
+	}
+	return Video{}, false
+}
+
+// EpisodeNumber returns the number of the episode within its season.
+func (v Video) EpisodeNumber() int {
+	// Cinemeta sets both "episode" and "number", but older responses only have "number"
+	if v.Episode != 0 {
+		return v.Episode
+	}
+	return v.Number
+}
+
+// EpisodeName returns the name of the episode, like "Winter Is Coming".
+func (v Video) EpisodeName() string {
+	// Cinemeta has the name in "name", but older responses only have it in "title"
+	if v.Name != "" {
+		return v.Name
+	}
+	return v.Title
+}
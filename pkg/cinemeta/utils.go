@@ -8,6 +8,11 @@ import (
 
 var ErrNoMeta = errors.New("no meta in context")
 
+var ErrNoVideo = errors.New("no video in context")
+
+// ErrEpisodeNotFound is returned by GetEpisode when the TV show exists, but doesn't have the requested episode.
+var ErrEpisodeNotFound = errors.New("episode not found")
+
 // GetMetaFromContext returns the Meta object that's stored in the context.
 // It returns an error if no meta was found in the context or the value found isn't of type Meta.
 // The former one is ErrNoMeta which acts as sentinel error so you can check for it.
@@ -21,3 +26,17 @@ func GetMetaFromContext(ctx context.Context) (Meta, error) {
 		return Meta{}, fmt.Errorf("couldn't turn meta interface value to proper object: type is %T", metaIface)
 	}
 }
+
+// GetVideoFromContext returns the Video object of a TV show episode that's stored in the context.
+// It returns an error if no video was found in the context or the value found isn't of type Video.
+// The former one is ErrNoVideo which acts as sentinel error so you can check for it.
+func GetVideoFromContext(ctx context.Context) (Video, error) {
+	videoIface := ctx.Value("video")
+	if videoIface == nil {
+		return Video{}, ErrNoVideo
+	} else if video, ok := videoIface.(Video); ok {
+		return video, nil
+	} else {
+		return Video{}, fmt.Errorf("couldn't turn video interface value to proper object: type is %T", videoIface)
+	}
+}
@@ -0,0 +1,38 @@
+// Package logging contains the small structured logging interface that go-stremio and its cinemeta package log to,
+// together with adapters for zap and log/slog.
+// This way the SDK's logs can flow into whatever structured logger your application uses.
+package logging
+
+// Logger is a structured, leveled logger.
+// Fields are passed as alternating keys and values, like with slog or zap's SugaredLogger:
+//
+//	logger.Info("Handled request", "status", 200, "url", "/manifest.json")
+//
+// Keys should be strings. Implementations must be safe for concurrent use.
+type Logger interface {
+	Debug(msg string, keysAndValues ...interface{})
+	Info(msg string, keysAndValues ...interface{})
+	Warn(msg string, keysAndValues ...interface{})
+	Error(msg string, keysAndValues ...interface{})
+	// With returns a logger that adds the given fields to each log entry.
+	With(keysAndValues ...interface{}) Logger
+}
+
+// Syncer is implemented by loggers that buffer log entries, like zap's.
+// go-stremio calls Sync when the addon shuts down.
+type Syncer interface {
+	Sync() error
+}
+
+// NewNopLogger returns a logger that discards all log entries.
+func NewNopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keysAndValues ...interface{}) {}
+func (nopLogger) Info(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Warn(msg string, keysAndValues ...interface{})  {}
+func (nopLogger) Error(msg string, keysAndValues ...interface{}) {}
+func (l nopLogger) With(keysAndValues ...interface{}) Logger     { return l }
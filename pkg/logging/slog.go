@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"context"
+	"log/slog"
+)
+
+var _ Logger = (*slogLogger)(nil)
+
+type slogLogger struct {
+	logger *slog.Logger
+}
+
+// NewSlogLogger wraps a slog logger, so it can be used as Logger.
+func NewSlogLogger(logger *slog.Logger) Logger {
+	return &slogLogger{
+		logger: logger,
+	}
+}
+
+// NewSlogHandlerLogger creates a Logger that passes log entries to the given slog handler.
+func NewSlogHandlerLogger(handler slog.Handler) Logger {
+	return NewSlogLogger(slog.New(handler))
+}
+
+func (l *slogLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelDebug, msg, keysAndValues...)
+}
+
+func (l *slogLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelInfo, msg, keysAndValues...)
+}
+
+func (l *slogLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelWarn, msg, keysAndValues...)
+}
+
+func (l *slogLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Log(context.Background(), slog.LevelError, msg, keysAndValues...)
+}
+
+func (l *slogLogger) With(keysAndValues ...interface{}) Logger {
+	return &slogLogger{
+		logger: l.logger.With(keysAndValues...),
+	}
+}
@@ -0,0 +1,46 @@
+package logging
+
+import (
+	"go.uber.org/zap"
+)
+
+var _ Logger = (*zapLogger)(nil)
+
+type zapLogger struct {
+	logger *zap.SugaredLogger
+}
+
+// NewZapLogger wraps a zap logger, so it can be used as Logger.
+func NewZapLogger(logger *zap.Logger) Logger {
+	// Skip the adapter's frame, so that the caller is reported correctly
+	return &zapLogger{
+		logger: logger.WithOptions(zap.AddCallerSkip(1)).Sugar(),
+	}
+}
+
+func (l *zapLogger) Debug(msg string, keysAndValues ...interface{}) {
+	l.logger.Debugw(msg, keysAndValues...)
+}
+
+func (l *zapLogger) Info(msg string, keysAndValues ...interface{}) {
+	l.logger.Infow(msg, keysAndValues...)
+}
+
+func (l *zapLogger) Warn(msg string, keysAndValues ...interface{}) {
+	l.logger.Warnw(msg, keysAndValues...)
+}
+
+func (l *zapLogger) Error(msg string, keysAndValues ...interface{}) {
+	l.logger.Errorw(msg, keysAndValues...)
+}
+
+func (l *zapLogger) With(keysAndValues ...interface{}) Logger {
+	return &zapLogger{
+		logger: l.logger.With(keysAndValues...),
+	}
+}
+
+// Sync flushes buffered log entries.
+func (l *zapLogger) Sync() error {
+	return l.logger.Sync()
+}
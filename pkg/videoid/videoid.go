@@ -0,0 +1,142 @@
+// Package videoid parses and formats Stremio video IDs, like "tt0133093" for a movie,
+// "tt0944947:1:2" for a TV show episode or "kitsu:1376:5" for an anime episode.
+package videoid
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// NamespaceIMDb is the namespace of IMDb IDs, which start with "tt" instead of a prefix followed by ":".
+const NamespaceIMDb = "imdb"
+
+// NamespaceKitsu is the namespace of Kitsu IDs, like "kitsu:1376".
+const NamespaceKitsu = "kitsu"
+
+var ErrNoID = errors.New("no video ID in context")
+
+// ID is a parsed Stremio video ID.
+type ID struct {
+	// NamespaceIMDb, NamespaceKitsu or any other prefix that's followed by ":" in the ID, like "myaddon".
+	Namespace string
+	// The ID of the movie, TV show, anime etc. without namespace, except for IMDb IDs, where it's the full IMDb ID like "tt0133093".
+	BaseID string
+	// Only set when HasSeason is true.
+	Season    int
+	HasSeason bool
+	// Only set when HasEpisode is true.
+	Episode    int
+	HasEpisode bool
+}
+
+// Parse parses a Stremio video ID. It supports the following formats:
+//
+//   - IMDb movie or TV show: "tt0133093" ("tt" followed by digits)
+//   - IMDb TV show episode: "tt0944947:1:2" (season 1, episode 2)
+//   - Kitsu anime: "kitsu:1376"
+//   - Kitsu anime episode: "kitsu:1376:5" (episode 5)
+//   - Other namespaces like the Kitsu ones, and additionally with season and episode: "myaddon:abc:1:2"
+//
+// Seasons and episodes must be non-negative integers.
+func Parse(id string) (ID, error) {
+	if id == "" {
+		return ID{}, errors.New("empty video ID")
+	}
+	parts := strings.Split(id, ":")
+	var result ID
+	var numbers []string
+	if isIMDbID(parts[0]) {
+		result.Namespace = NamespaceIMDb
+		result.BaseID = parts[0]
+		numbers = parts[1:]
+		if len(numbers) != 0 && len(numbers) != 2 {
+			return ID{}, fmt.Errorf("IMDb ID %q must have either no or both season and episode", id)
+		}
+	} else {
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return ID{}, fmt.Errorf("video ID %q has neither the IMDb format nor a namespace and base ID", id)
+		}
+		result.Namespace = parts[0]
+		result.BaseID = parts[1]
+		numbers = parts[2:]
+		if result.Namespace == NamespaceKitsu && len(numbers) > 1 {
+			return ID{}, fmt.Errorf("Kitsu ID %q can only have an episode", id)
+		} else if len(numbers) > 2 {
+			return ID{}, fmt.Errorf("video ID %q has too many parts", id)
+		}
+	}
+
+	ints := make([]int, len(numbers))
+	for i, s := range numbers {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return ID{}, fmt.Errorf("video ID %q has an invalid season or episode %q", id, s)
+		}
+		ints[i] = n
+	}
+	switch len(ints) {
+	case 1:
+		result.Episode, result.HasEpisode = ints[0], true
+	case 2:
+		result.Season, result.HasSeason = ints[0], true
+		result.Episode, result.HasEpisode = ints[1], true
+	}
+	return result, nil
+}
+
+// isIMDbID returns true for "tt" followed by only digits, like "tt0133093".
+func isIMDbID(s string) bool {
+	digits, ok := strings.CutPrefix(s, "tt")
+	if !ok || digits == "" {
+		return false
+	}
+	for _, r := range digits {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
+
+// String formats the ID the way Stremio does, so that parsing and formatting is lossless.
+func (id ID) String() string {
+	var sb strings.Builder
+	if id.Namespace != NamespaceIMDb {
+		sb.WriteString(id.Namespace)
+		sb.WriteByte(':')
+	}
+	sb.WriteString(id.BaseID)
+	if id.HasSeason {
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(id.Season))
+	}
+	if id.HasEpisode {
+		sb.WriteByte(':')
+		sb.WriteString(strconv.Itoa(id.Episode))
+	}
+	return sb.String()
+}
+
+// IsIMDb returns true for IMDb IDs, which can be looked up at Cinemeta.
+func (id ID) IsIMDb() bool {
+	return id.Namespace == NamespaceIMDb
+}
+
+// GetIDFromContext returns the parsed ID of a stream or meta request that's stored in the context.
+// It works with the context that's passed to stream and meta handlers.
+// It returns an error if no ID was found in the context or the value found isn't of type ID.
+// The former one is ErrNoID which acts as sentinel error so you can check for it.
+// IDs that couldn't be parsed are not stored in the context.
+func GetIDFromContext(ctx context.Context) (ID, error) {
+	idIface := ctx.Value("videoID")
+	if idIface == nil {
+		return ID{}, ErrNoID
+	} else if id, ok := idIface.(ID); ok {
+		return id, nil
+	} else {
+		return ID{}, fmt.Errorf("couldn't turn video ID interface value to proper object: type is %T", idIface)
+	}
+}
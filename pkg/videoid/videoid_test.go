@@ -0,0 +1,35 @@
+package videoid
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestParse(t *testing.T) {
+	tests := map[string]ID{
+		"tt0133093":       {Namespace: NamespaceIMDb, BaseID: "tt0133093"},
+		"tt0944947:1:2":   {Namespace: NamespaceIMDb, BaseID: "tt0944947", Season: 1, HasSeason: true, Episode: 2, HasEpisode: true},
+		"tt0944947:0:1":   {Namespace: NamespaceIMDb, BaseID: "tt0944947", Season: 0, HasSeason: true, Episode: 1, HasEpisode: true},
+		"kitsu:1376":      {Namespace: NamespaceKitsu, BaseID: "1376"},
+		"kitsu:1376:5":    {Namespace: NamespaceKitsu, BaseID: "1376", Episode: 5, HasEpisode: true},
+		"myaddon:abc":     {Namespace: "myaddon", BaseID: "abc"},
+		"myaddon:abc:1:2": {Namespace: "myaddon", BaseID: "abc", Season: 1, HasSeason: true, Episode: 2, HasEpisode: true},
+		// Namespaces that start like IMDb IDs
+		"ttv:123":  {Namespace: "ttv", BaseID: "123"},
+		"tt:123":   {Namespace: "tt", BaseID: "123"},
+		"tt1a:1:2": {Namespace: "tt1a", BaseID: "1", Episode: 2, HasEpisode: true},
+	}
+	for s, expected := range tests {
+		id, err := Parse(s)
+		require.NoError(t, err, s)
+		require.Equal(t, expected, id, s)
+		// Formatting is lossless
+		require.Equal(t, s, id.String())
+	}
+
+	for _, s := range []string{"", "tt0944947:1", "tt0944947:a:b", "tt0944947:1:-2", "kitsu:1376:1:5", "abc", "myaddon:", "myaddon:abc:1:2:3", "tt", "ttfoo", "tt123abc", "tt-1"} {
+		_, err := Parse(s)
+		require.Error(t, err, s)
+	}
+}
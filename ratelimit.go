@@ -0,0 +1,267 @@
+package stremio
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/cespare/xxhash/v2"
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+// RateLimit configures a token bucket.
+// A bucket holds up to Burst tokens and is refilled with Rate tokens per second. Each request takes one token.
+// The zero value disables the limit.
+type RateLimit struct {
+	// Number of tokens that are added to the bucket per second.
+	Rate float64 `config:"rate"`
+	// Maximum number of tokens in the bucket, so the number of requests that can be made in a short burst.
+	Burst int `config:"burst"`
+}
+
+func (rl RateLimit) enabled() bool {
+	return rl.Rate > 0 && rl.Burst > 0
+}
+
+// RateLimitOptions configure the optional rate limiting of requests.
+// All limits are disabled by default.
+type RateLimitOptions struct {
+	// Limit for all requests per client IP, except for the health check and metrics endpoints,
+	// which load balancers and metrics scrapers often request from a single IP.
+	PerIP RateLimit `config:"per_ip"`
+	// Limit for catalog, stream and meta requests per user.
+	// A user is identified by the user data in the URL (see UserIdentity), so requests without user data aren't limited by this.
+	PerUser RateLimit `config:"per_user"`
+	// Limits for specific resources per client IP.
+	// Valid keys are "catalog", "stream" and "meta".
+	// Example: `map[string]RateLimit{"stream": {Rate: 1, Burst: 10}}`.
+	PerResource map[string]RateLimit `config:"per_resource"`
+	// IPs or CIDR ranges of reverse proxies in front of the addon.
+	// When a request comes from one of them, the client IP is taken from the "X-Forwarded-For" header,
+	// skipping any further trusted proxies from right to left.
+	// Requests from other IPs can't spoof their IP via the header.
+	// Example: `[]string{"127.0.0.1", "10.0.0.0/8"}`.
+	TrustedProxies []string `config:"trusted_proxies"`
+	// Returns the identity of the user for PerUser limits.
+	// The userData parameter is the decoded user data if you called `RegisterUserData()` before, otherwise the user data string.
+	// If nil, the (undecoded) user data string itself is used as identity.
+	// Returning an empty string skips the PerUser limit for the request.
+	UserIdentity func(userData interface{}) string
+	// Storage of the token buckets.
+	// You can use this to share limits between multiple replicas of your addon, for example via Redis.
+	// If nil, an in-memory store is used.
+	Store RateLimitStore
+}
+
+// RateLimitStore stores token buckets.
+// Implementations must be safe for concurrent use.
+type RateLimitStore interface {
+	// Take takes a token from the bucket with the given key, creating a full bucket if it doesn't exist yet.
+	// If no token is left, it returns false and the duration after which a token will be available again.
+	Take(key string, limit RateLimit) (bool, time.Duration, error)
+}
+
+var _ RateLimitStore = (*InMemoryRateLimitStore)(nil)
+
+// InMemoryRateLimitStore is a RateLimitStore that keeps the token buckets in memory.
+// Buckets that have been refilled completely are removed periodically, because a new bucket starts full anyway.
+type InMemoryRateLimitStore struct {
+	buckets   map[string]*tokenBucket
+	lock      *sync.Mutex
+	lastSweep time.Time
+}
+
+type tokenBucket struct {
+	tokens  float64
+	updated time.Time
+	// The limit of the last Take, for determining when the bucket is full
+	limit RateLimit
+}
+
+// fullAt returns the time at which the bucket will be refilled completely.
+func (b *tokenBucket) fullAt() time.Time {
+	missing := float64(b.limit.Burst) - b.tokens
+	return b.updated.Add(time.Duration(missing / b.limit.Rate * float64(time.Second)))
+}
+
+// NewInMemoryRateLimitStore creates a new InMemoryRateLimitStore.
+func NewInMemoryRateLimitStore() *InMemoryRateLimitStore {
+	return &InMemoryRateLimitStore{
+		buckets:   map[string]*tokenBucket{},
+		lock:      &sync.Mutex{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Take takes a token from the bucket with the given key.
+func (s *InMemoryRateLimitStore) Take(key string, limit RateLimit) (bool, time.Duration, error) {
+	now := time.Now()
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	// Remove buckets that are full again, so the map doesn't grow with every client ever seen.
+	if now.Sub(s.lastSweep) > time.Minute {
+		for k, b := range s.buckets {
+			if !now.Before(b.fullAt()) {
+				delete(s.buckets, k)
+			}
+		}
+		s.lastSweep = now
+	}
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &tokenBucket{
+			tokens:  float64(limit.Burst),
+			updated: now,
+		}
+		s.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+		b.updated = now
+	}
+	b.limit = limit
+
+	if b.tokens < 1 {
+		missing := 1 - b.tokens
+		return false, time.Duration(missing / limit.Rate * float64(time.Second)), nil
+	}
+	b.tokens--
+	return true, 0, nil
+}
+
+func parseTrustedProxies(proxies []string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, proxy := range proxies {
+		if !strings.Contains(proxy, "/") {
+			if strings.Contains(proxy, ":") {
+				proxy += "/128"
+			} else {
+				proxy += "/32"
+			}
+		}
+		_, ipNet, err := net.ParseCIDR(proxy)
+		if err != nil {
+			return nil, fmt.Errorf("Couldn't parse trusted proxy %q: %w", proxy, err)
+		}
+		nets = append(nets, ipNet)
+	}
+	return nets, nil
+}
+
+func isTrustedProxy(ip net.IP, trustedProxies []*net.IPNet) bool {
+	for _, ipNet := range trustedProxies {
+		if ipNet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client.
+// If the request comes from a trusted proxy, the rightmost IP in the "X-Forwarded-For" header that isn't a trusted proxy is returned.
+func clientIP(c *fiber.Ctx, trustedProxies []*net.IPNet) string {
+	remoteIP := c.Context().RemoteIP()
+	if len(trustedProxies) == 0 || !isTrustedProxy(remoteIP, trustedProxies) {
+		return remoteIP.String()
+	}
+	forwardedFor := strings.Split(c.Get(fiber.HeaderXForwardedFor), ",")
+	for i := len(forwardedFor) - 1; i >= 0; i-- {
+		ip := net.ParseIP(strings.TrimSpace(forwardedFor[i]))
+		if ip == nil {
+			// Everything left of an invalid entry can't be trusted either
+			break
+		}
+		if !isTrustedProxy(ip, trustedProxies) {
+			return ip.String()
+		}
+	}
+	return remoteIP.String()
+}
+
+func rateLimitExceeded(c *fiber.Ctx, retryAfter time.Duration) error {
+	retryAfterSeconds := int64(math.Ceil(retryAfter.Seconds()))
+	if retryAfterSeconds < 1 {
+		retryAfterSeconds = 1
+	}
+	c.Set(fiber.HeaderRetryAfter, strconv.FormatInt(retryAfterSeconds, 10))
+	return c.SendStatus(fiber.StatusTooManyRequests)
+}
+
+// rateLimitExemptPaths are the paths of the operational endpoints that the per-IP limit doesn't apply to.
+var rateLimitExemptPaths = map[string]bool{
+	"/health":       true,
+	"/health/live":  true,
+	"/health/ready": true,
+	"/metrics":      true,
+}
+
+func createIPRateLimitMiddleware(store RateLimitStore, limit RateLimit, trustedProxies []*net.IPNet, logger logging.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if rateLimitExemptPaths[c.Path()] {
+			return c.Next()
+		}
+		ip := clientIP(c, trustedProxies)
+		allowed, retryAfter, err := store.Take("ip:"+ip, limit)
+		if err != nil {
+			// Rather let a request through than block all clients when the store has issues
+			logger.Error("Couldn't take token from rate limit store", "error", err)
+			return c.Next()
+		} else if !allowed {
+			logger.Debug("Rejecting request due to IP rate limit", "retryAfter", retryAfter)
+			return rateLimitExceeded(c, retryAfter)
+		}
+		return c.Next()
+	}
+}
+
+func createResourceRateLimitMiddleware(resource string, store RateLimitStore, resourceLimit, userLimit RateLimit, userIdentity func(interface{}) string, trustedProxies []*net.IPNet, logger logging.Logger, userDataType reflect.Type, userDataIsBase64 bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		if resourceLimit.enabled() {
+			ip := clientIP(c, trustedProxies)
+			allowed, retryAfter, err := store.Take("resource:"+resource+":"+ip, resourceLimit)
+			if err != nil {
+				logger.Error("Couldn't take token from rate limit store", "error", err)
+			} else if !allowed {
+				logger.Debug("Rejecting request due to resource rate limit", "resource", resource, "retryAfter", retryAfter)
+				return rateLimitExceeded(c, retryAfter)
+			}
+		}
+
+		userDataString := c.Params("userData")
+		if userLimit.enabled() && userDataString != "" {
+			identity := userDataString
+			if userIdentity != nil {
+				var userData interface{} = userDataString
+				if userDataType != nil {
+					var err error
+					if userData, err = decodeUserData(userDataString, userDataType, logger, userDataIsBase64); err != nil {
+						return c.SendStatus(fiber.StatusBadRequest)
+					}
+				}
+				identity = userIdentity(userData)
+			}
+			if identity != "" {
+				// Hash the identity so we don't keep secrets from the user data in the store
+				key := "user:" + strconv.FormatUint(xxhash.Sum64String(identity), 16)
+				allowed, retryAfter, err := store.Take(key, userLimit)
+				if err != nil {
+					logger.Error("Couldn't take token from rate limit store", "error", err)
+				} else if !allowed {
+					logger.Debug("Rejecting request due to user rate limit", "retryAfter", retryAfter)
+					return rateLimitExceeded(c, retryAfter)
+				}
+			}
+		}
+
+		return c.Next()
+	}
+}
@@ -0,0 +1,136 @@
+package stremio
+
+import (
+	"net"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestInMemoryRateLimitStore(t *testing.T) {
+	store := NewInMemoryRateLimitStore()
+	limit := RateLimit{Rate: 1, Burst: 2}
+
+	// The bucket starts full, so a burst is allowed
+	for i := 0; i < 2; i++ {
+		allowed, _, err := store.Take("foo", limit)
+		require.NoError(t, err)
+		require.True(t, allowed)
+	}
+	allowed, retryAfter, err := store.Take("foo", limit)
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.Greater(t, int64(retryAfter), int64(0))
+
+	// Other keys have their own bucket
+	allowed, _, err = store.Take("bar", limit)
+	require.NoError(t, err)
+	require.True(t, allowed)
+}
+
+func TestParseTrustedProxies(t *testing.T) {
+	nets, err := parseTrustedProxies([]string{"127.0.0.1", "10.0.0.0/8", "::1"})
+	require.NoError(t, err)
+	require.Len(t, nets, 3)
+	require.True(t, isTrustedProxy(net.ParseIP("10.1.2.3"), nets))
+	require.True(t, isTrustedProxy(net.ParseIP("::1"), nets))
+	require.False(t, isTrustedProxy(net.ParseIP("127.0.0.2"), nets))
+
+	_, err = parseTrustedProxies([]string{"foo"})
+	require.Error(t, err)
+}
+
+func TestInMemoryRateLimitStoreSweep(t *testing.T) {
+	store := NewInMemoryRateLimitStore()
+	// Refilling a token takes an hour, so an idle minute mustn't lead to a full bucket
+	limit := RateLimit{Rate: 1.0 / 3600, Burst: 1}
+	allowed, _, err := store.Take("foo", limit)
+	require.NoError(t, err)
+	require.True(t, allowed)
+	// A full bucket that can be removed
+	_, _, err = store.Take("bar", RateLimit{Rate: 1000, Burst: 1000})
+	require.NoError(t, err)
+
+	// Pretend the last take and sweep were a while ago
+	store.buckets["foo"].updated = store.buckets["foo"].updated.Add(-2 * time.Minute)
+	store.buckets["bar"].updated = store.buckets["bar"].updated.Add(-2 * time.Minute)
+	store.lastSweep = store.lastSweep.Add(-2 * time.Minute)
+
+	allowed, retryAfter, err := store.Take("foo", limit)
+	require.NoError(t, err)
+	require.False(t, allowed)
+	require.Greater(t, retryAfter, 50*time.Minute)
+	require.NotContains(t, store.buckets, "bar")
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	limit := RateLimit{Rate: 0.1, Burst: 1}
+	// fiber's app.Test() uses 0.0.0.0 as remote IP
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		// Second request, after the first one from 1.1.1.1
+		forwardedFor string
+		wantStatus   int
+	}{
+		{"untrusted proxy can't spoof IP", []string{"10.0.0.0/8"}, "2.2.2.2", fiber.StatusTooManyRequests},
+		{"trusted proxy", []string{"0.0.0.0"}, "2.2.2.2", fiber.StatusOK},
+		{"trusted proxy same client", []string{"0.0.0.0"}, "1.1.1.1", fiber.StatusTooManyRequests},
+		{"trusted proxy chain", []string{"0.0.0.0", "10.0.0.0/8"}, "1.1.1.1, 10.0.0.1", fiber.StatusTooManyRequests},
+		{"spoofed entry left of client", []string{"0.0.0.0"}, "1.1.1.1, 2.2.2.2", fiber.StatusOK},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			trustedProxies, err := parseTrustedProxies(tc.trustedProxies)
+			require.NoError(t, err)
+			app := fiber.New()
+			app.Use(createIPRateLimitMiddleware(NewInMemoryRateLimitStore(), limit, trustedProxies, logging.NewNopLogger()))
+			app.Get("/", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(fiber.HeaderXForwardedFor, "1.1.1.1")
+			res, err := app.Test(req)
+			require.NoError(t, err)
+			require.Equal(t, fiber.StatusOK, res.StatusCode)
+
+			req = httptest.NewRequest("GET", "/", nil)
+			req.Header.Set(fiber.HeaderXForwardedFor, tc.forwardedFor)
+			res, err = app.Test(req)
+			require.NoError(t, err)
+			require.Equal(t, tc.wantStatus, res.StatusCode)
+			if tc.wantStatus == fiber.StatusTooManyRequests {
+				// 1 token per 10 seconds
+				require.Equal(t, "10", res.Header.Get(fiber.HeaderRetryAfter))
+			}
+		})
+	}
+}
+
+func TestRateLimitMiddlewareExemptPaths(t *testing.T) {
+	app := fiber.New()
+	app.Use(createIPRateLimitMiddleware(NewInMemoryRateLimitStore(), RateLimit{Rate: 0.1, Burst: 1}, nil, logging.NewNopLogger()))
+	app.Get("/", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	app.Get("/health/ready", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+	app.Get("/metrics", func(c *fiber.Ctx) error { return c.SendStatus(fiber.StatusOK) })
+
+	res, err := app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, res.StatusCode)
+	res, err = app.Test(httptest.NewRequest("GET", "/", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusTooManyRequests, res.StatusCode)
+
+	// Health checks and metrics scrapes from the same IP still work
+	for i := 0; i < 3; i++ {
+		for _, path := range []string{"/health/ready", "/metrics"} {
+			res, err = app.Test(httptest.NewRequest("GET", path, nil))
+			require.NoError(t, err)
+			require.Equal(t, fiber.StatusOK, res.StatusCode, path)
+		}
+	}
+}
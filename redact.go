@@ -0,0 +1,117 @@
+package stremio
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"reflect"
+	"strings"
+)
+
+// redactedValue replaces secret values in logs.
+const redactedValue = "[REDACTED]"
+
+// userDataPathSegments are the path segments that can follow the user data in a URL path.
+var userDataPathSegments = []string{"manifest.json", "catalog", "stream", "meta", "configure"}
+
+// fingerprintKey is the key for the fingerprint HMAC.
+// It's random per process, so that user data with low entropy (like short API keys) can't be brute-forced from the fingerprints in logs.
+// As a consequence, fingerprints can only be correlated within the logs of one process.
+var fingerprintKey = newFingerprintKey()
+
+func newFingerprintKey() []byte {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("Couldn't generate fingerprint key: %v", err))
+	}
+	return key
+}
+
+// userDataFingerprint returns a stable fingerprint of the user data, so that requests of the same user can be correlated in logs without logging the user data itself.
+func userDataFingerprint(userData string) string {
+	mac := hmac.New(sha256.New, fingerprintKey)
+	mac.Write([]byte(userData))
+	// 64 bits are enough for correlating requests
+	return "userData-" + hex.EncodeToString(mac.Sum(nil)[:8])
+}
+
+// redactURL replaces the user data segment of a URL by its fingerprint.
+// Only URLs of the Stremio endpoints are redacted, like "/:userData/manifest.json" or "/:userData/stream/:type/:id.json".
+func redactURL(url string) string {
+	if len(url) < 2 || url[0] != '/' {
+		return url
+	}
+	end := strings.IndexByte(url[1:], '/')
+	if end == -1 {
+		return url
+	}
+	userData, rest := url[1:end+1], url[end+1:]
+	for _, segment := range userDataPathSegments {
+		if rest == "/"+segment || strings.HasPrefix(rest, "/"+segment+"/") || strings.HasPrefix(rest, "/"+segment+"?") {
+			return "/" + userDataFingerprint(userData) + rest
+		}
+	}
+	return url
+}
+
+// redactUserData returns a string representation of the decoded user data like fmt's "%+v" verb,
+// but with the values of fields that are tagged with `stremio:"secret"` replaced.
+// Only top-level fields of structs are considered.
+func redactUserData(userData interface{}) string {
+	v := reflect.ValueOf(userData)
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return fmt.Sprintf("%+v", userData)
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return fmt.Sprintf("%+v", userData)
+	}
+
+	t := v.Type()
+	var sb strings.Builder
+	sb.WriteByte('{')
+	for i := 0; i < t.NumField(); i++ {
+		if i > 0 {
+			sb.WriteByte(' ')
+		}
+		field := t.Field(i)
+		sb.WriteString(field.Name)
+		sb.WriteByte(':')
+		if isSecretField(field) {
+			sb.WriteString(redactedValue)
+		} else {
+			// fmt prints the value that the reflect.Value holds, even for unexported fields
+			fmt.Fprintf(&sb, "%+v", v.Field(i))
+		}
+	}
+	sb.WriteByte('}')
+	return sb.String()
+}
+
+func isSecretField(field reflect.StructField) bool {
+	for _, option := range strings.Split(field.Tag.Get("stremio"), ",") {
+		if option == "secret" {
+			return true
+		}
+	}
+	return false
+}
+
+// anonymizeIP removes the last octet of IPv4 addresses and the last 80 bits of IPv6 addresses,
+// similar to what Google Analytics does.
+// Invalid IPs are returned unchanged.
+func anonymizeIP(ip string) string {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return ip
+	}
+	if ipv4 := parsed.To4(); ipv4 != nil {
+		return ipv4.Mask(net.CIDRMask(24, 32)).String()
+	}
+	return parsed.Mask(net.CIDRMask(48, 128)).String()
+}
@@ -0,0 +1,54 @@
+package stremio
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestRedactURL(t *testing.T) {
+	fingerprint := userDataFingerprint("secret")
+	tests := []struct {
+		url      string
+		expected string
+	}{
+		{"/manifest.json", "/manifest.json"},
+		{"/stream/movie/tt1254207.json", "/stream/movie/tt1254207.json"},
+		{"/secret/manifest.json", "/" + fingerprint + "/manifest.json"},
+		{"/secret/stream/movie/tt1254207.json", "/" + fingerprint + "/stream/movie/tt1254207.json"},
+		{"/secret/configure", "/" + fingerprint + "/configure"},
+		{"/debug/pprof/heap", "/debug/pprof/heap"},
+		{"/foo/bar", "/foo/bar"},
+	}
+	for _, tt := range tests {
+		require.Equal(t, tt.expected, redactURL(tt.url), tt.url)
+	}
+}
+
+func TestRedactUserData(t *testing.T) {
+	type userData struct {
+		UserID string `json:"userId"`
+		Token  string `json:"token" stremio:"secret"`
+	}
+	require.Equal(t, "{UserID:123 Token:[REDACTED]}", redactUserData(&userData{UserID: "123", Token: "abc"}))
+	require.Equal(t, "foo", redactUserData("foo"))
+}
+
+func TestAnonymizeIP(t *testing.T) {
+	require.Equal(t, "192.168.1.0", anonymizeIP("192.168.1.123"))
+	require.Equal(t, "2001:db8:85a3::", anonymizeIP("2001:db8:85a3:8d3:1319:8a2e:370:7348"))
+	require.Equal(t, "foo", anonymizeIP("foo"))
+}
+
+func TestUserDataFingerprint(t *testing.T) {
+	require.Equal(t, userDataFingerprint("secret"), userDataFingerprint("secret"))
+	require.NotEqual(t, userDataFingerprint("secret"), userDataFingerprint("secret2"))
+	require.Len(t, userDataFingerprint("secret"), len("userData-")+16)
+
+	// Keyed, so it can't be computed without the process's key
+	original := fingerprintKey
+	defer func() { fingerprintKey = original }()
+	fingerprint := userDataFingerprint("secret")
+	fingerprintKey = newFingerprintKey()
+	require.NotEqual(t, fingerprint, userDataFingerprint("secret"))
+}
@@ -0,0 +1,330 @@
+package stremio
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ReloadCallback is called when the addon receives a SIGHUP signal.
+// You can use it to reload the manifest and handlers, for example from a config file or database,
+// and set them with Addon.SetManifest(), Addon.SetCatalogHandler() etc.
+// An error is logged, but the addon keeps running with the state it had before.
+type ReloadCallback func() error
+
+// handlerSet contains all handlers of an addon, as registered by the user.
+type handlerSet struct {
+	catalog map[string]CatalogHandler
+	// By type and catalog ID
+	catalogByID map[string]map[string]CatalogHandler
+	stream      map[string]StreamHandler
+	// By type and ID prefix
+	streamByPrefix map[string]map[string]StreamHandler
+	meta           map[string]MetaHandler
+	// By type and ID prefix
+	metaByPrefix map[string]map[string]MetaHandler
+}
+
+func (h handlerSet) empty() bool {
+	return len(h.catalog) == 0 && len(h.catalogByID) == 0 && len(h.stream) == 0 && len(h.streamByPrefix) == 0 && len(h.meta) == 0 && len(h.metaByPrefix) == 0
+}
+
+// prefixHandler is a handler for media IDs with a specific prefix, like "kitsu:".
+type prefixHandler struct {
+	prefix  string
+	handler handler
+}
+
+// convertPrefixHandlers converts the handlers by type and prefix to slices that are sorted by prefix length, longest first,
+// so that the first matching prefix is the longest one.
+func convertPrefixHandlers[H any](handlers map[string]map[string]H, convert func(H) handler) map[string][]prefixHandler {
+	result := make(map[string][]prefixHandler, len(handlers))
+	for t, byPrefix := range handlers {
+		for prefix, h := range byPrefix {
+			result[t] = append(result[t], prefixHandler{prefix: prefix, handler: convert(h)})
+		}
+		sort.Slice(result[t], func(i, j int) bool {
+			return len(result[t][i].prefix) > len(result[t][j].prefix)
+		})
+	}
+	return result
+}
+
+// findPrefixHandler returns the handler with the longest prefix that matches the ID, or else the handler for the whole type.
+func findPrefixHandler(prefixHandlers map[string][]prefixHandler, typeHandlers map[string]handler, t, id string) (handler, bool) {
+	for _, ph := range prefixHandlers[t] {
+		if strings.HasPrefix(id, ph.prefix) {
+			return ph.handler, true
+		}
+	}
+	h, ok := typeHandlers[t]
+	return h, ok
+}
+
+// addonState is the manifest and the handlers that the addon serves.
+// It's never modified but replaced as a whole, so that each request sees a consistent state
+// and in-flight requests finish with the state they started with.
+type addonState struct {
+	manifest Manifest
+	// Marshalled once per state instead of for each request
+	manifestBody           []byte
+	configuredManifestBody []byte
+	// Catalog IDs by type, as declared in the manifest
+	declaredCatalogs map[string]map[string]bool
+	requestRules     requestRules
+
+	handlers handlerSet
+
+	// The handlers above, converted to the common handler type
+	catalogHandlersConverted   map[string]handler
+	catalogIDHandlersConverted map[string]map[string]handler
+	streamHandlersConverted    map[string]handler
+	streamPrefixHandlers       map[string][]prefixHandler
+	metaHandlersConverted      map[string]handler
+	metaPrefixHandlers         map[string][]prefixHandler
+}
+
+func newAddonState(manifest Manifest, handlers handlerSet) (*addonState, error) {
+	// Clone so that later changes by the caller don't affect the served manifest
+	manifest = manifest.clone()
+	manifestBody, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't marshal manifest: %w", err)
+	}
+	// When there's user data we want Stremio to show the "Install" button, which it only does when "configurationRequired" is false.
+	// To not change the boolean value of the manifest object on the fly and thus mess with a single object across concurrent goroutines, we copy it and marshal two different objects.
+	// Note that this manifest copy has some values shallowly copied, but `BehaviorHints.ConfigurationRequired` is a simple type and thus a real copy.
+	configuredManifest := manifest
+	configuredManifest.BehaviorHints.ConfigurationRequired = false
+	configuredManifestBody, err := json.Marshal(configuredManifest)
+	if err != nil {
+		return nil, fmt.Errorf("Couldn't marshal configured manifest: %w", err)
+	}
+
+	declaredCatalogs := map[string]map[string]bool{}
+	for _, catalog := range manifest.Catalogs {
+		if declaredCatalogs[catalog.Type] == nil {
+			declaredCatalogs[catalog.Type] = map[string]bool{}
+		}
+		declaredCatalogs[catalog.Type][catalog.ID] = true
+	}
+
+	s := &addonState{
+		manifest:               manifest,
+		manifestBody:           manifestBody,
+		configuredManifestBody: configuredManifestBody,
+		declaredCatalogs:       declaredCatalogs,
+		requestRules:           newRequestRules(manifest),
+	}
+	s.setHandlers(handlers)
+	return s, nil
+}
+
+// setHandlers sets the handlers and their converted versions.
+// It must only be called before the state is stored in the addon.
+func (s *addonState) setHandlers(handlers handlerSet) {
+	s.handlers = handlers
+	s.catalogHandlersConverted = make(map[string]handler, len(handlers.catalog))
+	for k, v := range handlers.catalog {
+		s.catalogHandlersConverted[k] = convertCatalogHandler(v)
+	}
+	s.catalogIDHandlersConverted = make(map[string]map[string]handler, len(handlers.catalogByID))
+	for t, byID := range handlers.catalogByID {
+		s.catalogIDHandlersConverted[t] = make(map[string]handler, len(byID))
+		for k, v := range byID {
+			s.catalogIDHandlersConverted[t][k] = convertCatalogHandler(v)
+		}
+	}
+	s.streamHandlersConverted = make(map[string]handler, len(handlers.stream))
+	for k, v := range handlers.stream {
+		s.streamHandlersConverted[k] = convertStreamHandler(v)
+	}
+	s.streamPrefixHandlers = convertPrefixHandlers(handlers.streamByPrefix, convertStreamHandler)
+	s.metaHandlersConverted = make(map[string]handler, len(handlers.meta))
+	for k, v := range handlers.meta {
+		s.metaHandlersConverted[k] = convertMetaHandler(v)
+	}
+	s.metaPrefixHandlers = convertPrefixHandlers(handlers.metaByPrefix, convertMetaHandler)
+}
+
+// findCatalogHandler returns the handler for the catalog with the given type and ID.
+// Catalogs that aren't declared in the manifest don't have a handler.
+// A handler for the specific catalog takes precedence over the handler for the type.
+func (s *addonState) findCatalogHandler(t, id string) (handler, bool) {
+	if !s.declaredCatalogs[t][id] {
+		return nil, false
+	}
+	if h, ok := s.catalogIDHandlersConverted[t][id]; ok {
+		return h, true
+	}
+	h, ok := s.catalogHandlersConverted[t]
+	return h, ok
+}
+
+// findStreamHandler returns the stream handler for the given type and media ID.
+// A handler for the longest matching ID prefix takes precedence over the handler for the type.
+func (s *addonState) findStreamHandler(t, id string) (handler, bool) {
+	return findPrefixHandler(s.streamPrefixHandlers, s.streamHandlersConverted, t, id)
+}
+
+// findMetaHandler returns the meta handler for the given type and media ID.
+// A handler for the longest matching ID prefix takes precedence over the handler for the type.
+func (s *addonState) findMetaHandler(t, id string) (handler, bool) {
+	return findPrefixHandler(s.metaPrefixHandlers, s.metaHandlersConverted, t, id)
+}
+
+// SetManifest atomically replaces the manifest that the addon serves.
+// It can be called while the addon is running. Requests that are already being handled still use the previous manifest.
+// The behavior hints `Configurable` and `ConfigurationRequired` can't be changed, because the registered routes depend on them.
+// With StrictManifestValidation, the manifest is validated against the current handlers like in NewAddon, and rejected if it doesn't match them.
+// So when the new manifest advertises new types or catalogs, set their handlers first.
+func (a *Addon) SetManifest(manifest Manifest) error {
+	if manifest.ID == "" || manifest.Name == "" || manifest.Description == "" || manifest.Version == "" {
+		return errors.New("An empty manifest was passed")
+	}
+
+	a.stateLock.Lock()
+	defer a.stateLock.Unlock()
+	current := a.state.Load()
+	if manifest.BehaviorHints.Configurable != current.manifest.BehaviorHints.Configurable ||
+		manifest.BehaviorHints.ConfigurationRequired != current.manifest.BehaviorHints.ConfigurationRequired {
+		return errors.New("Changing whether the addon is configurable or requires a configuration isn't supported at runtime")
+	}
+	if a.opts.StrictManifestValidation {
+		if err := validateManifest(manifest, current.handlers); err != nil {
+			return fmt.Errorf("Manifest doesn't match the handlers: %w", err)
+		}
+	}
+	s, err := newAddonState(manifest, current.handlers)
+	if err != nil {
+		return err
+	}
+	a.state.Store(s)
+	return nil
+}
+
+// SetCatalogHandler atomically adds or replaces the catalog handler for the given type (like "movie").
+// A nil handler removes the existing one.
+// It can be called while the addon is running. Requests that are already being handled still use the previous handler.
+// Don't forget to declare new catalogs in the manifest via SetManifest().
+func (a *Addon) SetCatalogHandler(t string, catalogHandler CatalogHandler) {
+	a.stateLock.Lock()
+	defer a.stateLock.Unlock()
+	current := a.state.Load()
+	handlers := current.handlers
+	handlers.catalog = setHandler(handlers.catalog, t, catalogHandler, catalogHandler == nil)
+	a.storeHandlers(current, handlers)
+}
+
+// SetCatalogIDHandler atomically adds or replaces the catalog handler for the catalog with the given type (like "movie") and ID (like "popular").
+// It takes precedence over the handler for the whole type that was set via SetCatalogHandler() or passed to NewAddon().
+// A nil handler removes the existing one.
+// It can be called while the addon is running. Requests that are already being handled still use the previous handler.
+// Don't forget to declare new catalogs in the manifest via SetManifest(), as requests for undeclared catalogs are responded to with 404.
+func (a *Addon) SetCatalogIDHandler(t, catalogID string, catalogHandler CatalogHandler) {
+	a.stateLock.Lock()
+	defer a.stateLock.Unlock()
+	current := a.state.Load()
+	handlers := current.handlers
+	handlers.catalogByID = setNestedHandler(handlers.catalogByID, t, catalogID, catalogHandler, catalogHandler == nil)
+	a.storeHandlers(current, handlers)
+}
+
+// SetStreamHandler atomically adds or replaces the stream handler for the given type (like "movie").
+// A nil handler removes the existing one.
+// It can be called while the addon is running. Requests that are already being handled still use the previous handler.
+func (a *Addon) SetStreamHandler(t string, streamHandler StreamHandler) {
+	a.stateLock.Lock()
+	defer a.stateLock.Unlock()
+	current := a.state.Load()
+	handlers := current.handlers
+	handlers.stream = setHandler(handlers.stream, t, streamHandler, streamHandler == nil)
+	a.storeHandlers(current, handlers)
+}
+
+// SetStreamPrefixHandler atomically adds or replaces the stream handler for IDs with the given prefix (like "kitsu:") of the given type (like "series").
+// When multiple prefixes match an ID, the longest one wins. When none matches, the handler for the whole type is used.
+// A nil handler removes the existing one.
+// It can be called while the addon is running. Requests that are already being handled still use the previous handler.
+// Don't forget to declare the prefix in the manifest's ResourceItem.IDprefixes via SetManifest(), otherwise Stremio might not send requests for it.
+func (a *Addon) SetStreamPrefixHandler(t, idPrefix string, streamHandler StreamHandler) {
+	a.stateLock.Lock()
+	defer a.stateLock.Unlock()
+	current := a.state.Load()
+	handlers := current.handlers
+	handlers.streamByPrefix = setNestedHandler(handlers.streamByPrefix, t, idPrefix, streamHandler, streamHandler == nil)
+	a.storeHandlers(current, handlers)
+}
+
+// SetMetaHandler atomically adds or replaces the meta handler for the given type (like "movie").
+// A nil handler removes the existing one.
+// It can be called while the addon is running. Requests that are already being handled still use the previous handler.
+func (a *Addon) SetMetaHandler(t string, metaHandler MetaHandler) {
+	a.stateLock.Lock()
+	defer a.stateLock.Unlock()
+	current := a.state.Load()
+	handlers := current.handlers
+	handlers.meta = setHandler(handlers.meta, t, metaHandler, metaHandler == nil)
+	a.storeHandlers(current, handlers)
+}
+
+// SetMetaPrefixHandler atomically adds or replaces the meta handler for IDs with the given prefix (like "kitsu:") of the given type (like "series").
+// When multiple prefixes match an ID, the longest one wins. When none matches, the handler for the whole type is used.
+// A nil handler removes the existing one.
+// It can be called while the addon is running. Requests that are already being handled still use the previous handler.
+// Don't forget to declare the prefix in the manifest's ResourceItem.IDprefixes via SetManifest(), otherwise Stremio might not send requests for it.
+func (a *Addon) SetMetaPrefixHandler(t, idPrefix string, metaHandler MetaHandler) {
+	a.stateLock.Lock()
+	defer a.stateLock.Unlock()
+	current := a.state.Load()
+	handlers := current.handlers
+	handlers.metaByPrefix = setNestedHandler(handlers.metaByPrefix, t, idPrefix, metaHandler, metaHandler == nil)
+	a.storeHandlers(current, handlers)
+}
+
+// storeHandlers stores a new state with the current manifest and the given handlers.
+// The state lock must be held.
+func (a *Addon) storeHandlers(current *addonState, handlers handlerSet) {
+	// The manifest and its marshalled bodies are never modified, so they can be shared
+	s := *current
+	s.setHandlers(handlers)
+	a.state.Store(&s)
+}
+
+// setHandler returns a copy of the handler map with the handler for the key added or replaced, or removed if remove is true.
+// The original map isn't modified, because it's still used by the current state.
+func setHandler[H any](handlers map[string]H, key string, h H, remove bool) map[string]H {
+	result := make(map[string]H, len(handlers)+1)
+	for k, v := range handlers {
+		result[k] = v
+	}
+	if remove {
+		delete(result, key)
+	} else {
+		result[key] = h
+	}
+	return result
+}
+
+// setNestedHandler is like setHandler, but for handler maps with two levels of keys, like type and catalog ID.
+func setNestedHandler[H any](handlers map[string]map[string]H, key1, key2 string, h H, remove bool) map[string]map[string]H {
+	result := make(map[string]map[string]H, len(handlers)+1)
+	for k, v := range handlers {
+		result[k] = v
+	}
+	result[key1] = setHandler(handlers[key1], key2, h, remove)
+	if len(result[key1]) == 0 {
+		delete(result, key1)
+	}
+	return result
+}
+
+// SetReloadCallback sets the callback that's called when the addon receives a SIGHUP signal while running.
+// It must be called before Run(), because Run() only listens for SIGHUP when a callback is set.
+func (a *Addon) SetReloadCallback(callback ReloadCallback) {
+	a.stateLock.Lock()
+	defer a.stateLock.Unlock()
+	a.reloadCallback = callback
+}
@@ -0,0 +1,158 @@
+package stremio
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestSetManifestAndHandlers(t *testing.T) {
+	manifest := Manifest{ID: "foo", Name: "Foo", Description: "Foo addon", Version: "0.1.0"}
+	streamHandler := func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		return []StreamItem{{URL: "https://example.com/foo.mp4"}}, nil
+	}
+	addon, err := NewAddon(manifest, nil, map[string]StreamHandler{"movie": streamHandler}, nil, Options{Logger: logging.NewNopLogger()})
+	require.NoError(t, err)
+
+	getState := func() *addonState { return addon.state.Load() }
+	app := fiber.New()
+	app.Get("/manifest.json", createManifestHandler(getState, logging.NewNopLogger(), nil, nil, false))
+	app.Get("/stream/:type/:id.json", createStreamHandler(func(t, id string) (handler, bool) { return getState().findStreamHandler(t, id) }, 0, false, false, 0, 0, context.Background(), logging.NewNopLogger(), nil, false))
+
+	// Manifest
+	manifest.Version = "0.2.0"
+	require.NoError(t, addon.SetManifest(manifest))
+	res, err := app.Test(httptest.NewRequest("GET", "/manifest.json", nil))
+	require.NoError(t, err)
+	body, err := io.ReadAll(res.Body)
+	require.NoError(t, err)
+	require.Contains(t, string(body), `"version":"0.2.0"`)
+
+	manifest.BehaviorHints.Configurable = true
+	require.Error(t, addon.SetManifest(manifest))
+
+	// Handlers
+	res, err = app.Test(httptest.NewRequest("GET", "/stream/series/tt1.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusNotFound, res.StatusCode)
+
+	addon.SetStreamHandler("series", streamHandler)
+	res, err = app.Test(httptest.NewRequest("GET", "/stream/series/tt1.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, res.StatusCode)
+
+	addon.SetStreamHandler("movie", nil)
+	res, err = app.Test(httptest.NewRequest("GET", "/stream/movie/tt1.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusNotFound, res.StatusCode)
+}
+
+func TestCatalogIDHandlers(t *testing.T) {
+	manifest := Manifest{
+		ID:          "foo",
+		Name:        "Foo",
+		Description: "Foo addon",
+		Version:     "0.1.0",
+		Catalogs: []CatalogItem{
+			{Type: "movie", ID: "popular", Name: "Popular movies"},
+			{Type: "movie", ID: "new", Name: "New movies"},
+		},
+	}
+	newCatalogHandler := func(name string) CatalogHandler {
+		return func(ctx context.Context, id string, userData interface{}) ([]MetaPreviewItem, error) {
+			return []MetaPreviewItem{{ID: "tt1", Type: "movie", Name: name}}, nil
+		}
+	}
+	addon, err := NewAddon(manifest, map[string]CatalogHandler{"movie": newCatalogHandler("type")}, nil, nil, Options{Logger: logging.NewNopLogger()})
+	require.NoError(t, err)
+	addon.SetCatalogIDHandler("movie", "popular", newCatalogHandler("popular"))
+
+	app := fiber.New()
+	app.Get("/catalog/:type/:id.json", createCatalogHandler(func(t, id string) (handler, bool) { return addon.state.Load().findCatalogHandler(t, id) }, 0, false, false, 0, 0, context.Background(), logging.NewNopLogger(), nil, false))
+
+	for path, expected := range map[string]string{
+		"/catalog/movie/popular.json": "popular",
+		"/catalog/movie/new.json":     "type",
+	} {
+		res, err := app.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), `"name":"`+expected+`"`)
+	}
+
+	// Undeclared catalog
+	res, err := app.Test(httptest.NewRequest("GET", "/catalog/movie/foo.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusNotFound, res.StatusCode)
+}
+
+func TestPrefixHandlers(t *testing.T) {
+	newStreamHandler := func(name string) StreamHandler {
+		return func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+			return []StreamItem{{URL: "https://example.com/" + name + ".mp4"}}, nil
+		}
+	}
+	addon, err := NewManifestBuilder("foo", "Foo", "Foo addon", "0.1.0").
+		StreamHandler("series", newStreamHandler("type"), "tt").
+		StreamPrefixHandler("series", "myaddon:", newStreamHandler("myaddon")).
+		StreamPrefixHandler("series", "myaddon:special:", newStreamHandler("special")).
+		NewAddon(Options{Logger: logging.NewNopLogger()})
+	require.NoError(t, err)
+
+	app := fiber.New()
+	app.Get("/stream/:type/:id.json", createStreamHandler(func(t, id string) (handler, bool) { return addon.state.Load().findStreamHandler(t, id) }, 0, false, false, 0, 0, context.Background(), logging.NewNopLogger(), nil, false))
+
+	for path, expected := range map[string]string{
+		"/stream/series/tt1:1:1.json":             "type",
+		"/stream/series/myaddon:123.json":         "myaddon",
+		"/stream/series/myaddon:special:123.json": "special",
+		"/stream/series/kitsu:123.json":           "type",
+	} {
+		res, err := app.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+		body, err := io.ReadAll(res.Body)
+		require.NoError(t, err)
+		require.Contains(t, string(body), "/"+expected+".mp4", path)
+	}
+
+	// Without a handler for the whole type, IDs without matching prefix aren't handled
+	addon.SetStreamHandler("series", nil)
+	res, err := app.Test(httptest.NewRequest("GET", "/stream/series/tt1:1:1.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusNotFound, res.StatusCode)
+}
+
+func TestSetManifestStrictValidation(t *testing.T) {
+	manifest := Manifest{
+		ID:            "foo",
+		Name:          "Foo",
+		Description:   "Foo addon",
+		Version:       "0.1.0",
+		ResourceItems: []ResourceItem{{Name: "stream"}},
+		Types:         []string{"movie"},
+		Catalogs:      []CatalogItem{},
+	}
+	streamHandler := func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		return nil, nil
+	}
+	addon, err := NewAddon(manifest, nil, map[string]StreamHandler{"movie": streamHandler}, nil, Options{Logger: logging.NewNopLogger(), StrictManifestValidation: true})
+	require.NoError(t, err)
+
+	// A type without handler is rejected
+	manifest.Types = []string{"movie", "series"}
+	require.ErrorContains(t, addon.SetManifest(manifest), `"series"`)
+	require.Equal(t, "0.1.0", addon.state.Load().manifest.Version)
+
+	// Setting the handler first works
+	addon.SetStreamHandler("series", streamHandler)
+	manifest.Version = "0.2.0"
+	require.NoError(t, addon.SetManifest(manifest))
+	require.Equal(t, "0.2.0", addon.state.Load().manifest.Version)
+}
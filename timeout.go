@@ -0,0 +1,80 @@
+package stremio
+
+import (
+	"fmt"
+	"sync"
+)
+
+// TimeoutFallback defines how the addon responds when a handler doesn't return before its timeout.
+type TimeoutFallback int
+
+const (
+	// TimeoutFallbackStatus leads to a "504 Gateway Timeout" response.
+	TimeoutFallbackStatus TimeoutFallback = iota
+	// TimeoutFallbackEmpty leads to a response with an empty list of catalog items / streams.
+	// Meta requests get a "504 Gateway Timeout" response, as they don't return a list.
+	TimeoutFallbackEmpty
+	// TimeoutFallbackCache leads to the last successful response for the same URL being returned.
+	// If there is none, the response is a "504 Gateway Timeout".
+	TimeoutFallbackCache
+)
+
+// timeoutFallbackNames are the names of the fallbacks in config files and environment variables.
+var timeoutFallbackNames = []string{"status", "empty", "cache"}
+
+// MarshalText implements encoding.TextMarshaler.
+func (tf TimeoutFallback) MarshalText() ([]byte, error) {
+	if tf < 0 || int(tf) >= len(timeoutFallbackNames) {
+		return nil, fmt.Errorf("Unknown timeout fallback %d", tf)
+	}
+	return []byte(timeoutFallbackNames[tf]), nil
+}
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+// Valid values are "status", "empty" and "cache".
+func (tf *TimeoutFallback) UnmarshalText(text []byte) error {
+	for i, name := range timeoutFallbackNames {
+		if string(text) == name {
+			*tf = TimeoutFallback(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("Unknown timeout fallback %q", text)
+}
+
+// maxFallbackResponses is the maximum number of responses that are kept for the TimeoutFallbackCache fallback per resource.
+const maxFallbackResponses = 10000
+
+// fallbackCache stores the last successful response bodies for the TimeoutFallbackCache fallback.
+// It's bounded by maxFallbackResponses. When it's full, a random entry is evicted.
+type fallbackCache struct {
+	bodies map[string][]byte
+	lock   *sync.RWMutex
+}
+
+func newFallbackCache() *fallbackCache {
+	return &fallbackCache{
+		bodies: map[string][]byte{},
+		lock:   &sync.RWMutex{},
+	}
+}
+
+func (fc *fallbackCache) set(key string, body []byte) {
+	fc.lock.Lock()
+	defer fc.lock.Unlock()
+	if _, ok := fc.bodies[key]; !ok && len(fc.bodies) >= maxFallbackResponses {
+		// Map iteration order is random
+		for k := range fc.bodies {
+			delete(fc.bodies, k)
+			break
+		}
+	}
+	fc.bodies[key] = body
+}
+
+func (fc *fallbackCache) get(key string) ([]byte, bool) {
+	fc.lock.RLock()
+	defer fc.lock.RUnlock()
+	body, ok := fc.bodies[key]
+	return body, ok
+}
@@ -0,0 +1,115 @@
+package stremio
+
+import (
+	"context"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/valyala/fasthttp"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.24.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the name of the OpenTelemetry tracer, which is the package's import path by convention.
+const tracerName = "github.com/deflix-tv/go-stremio"
+
+// headerCarrier adapts fasthttp's request header to the OpenTelemetry propagation.TextMapCarrier interface.
+type headerCarrier struct {
+	header *fasthttp.RequestHeader
+}
+
+func (hc headerCarrier) Get(key string) string {
+	return string(hc.header.Peek(key))
+}
+
+func (hc headerCarrier) Set(key string, value string) {
+	hc.header.Set(key, value)
+}
+
+func (hc headerCarrier) Keys() []string {
+	var keys []string
+	hc.header.VisitAll(func(key, _ []byte) {
+		keys = append(keys, string(key))
+	})
+	return keys
+}
+
+// createTracingMiddleware creates a middleware that starts a server span for each request.
+// The span is put into the request's user context (see fiber.Ctx.UserContext()), so that later middlewares and handlers can create child spans.
+func createTracingMiddleware(tracerProvider trace.TracerProvider, propagator propagation.TextMapPropagator, redact bool) fiber.Handler {
+	tracer := tracerProvider.Tracer(tracerName)
+
+	return func(c *fiber.Ctx) error {
+		ctx := propagator.Extract(c.UserContext(), headerCarrier{&c.Request().Header})
+		// Spans are exported after the request is done, when Fiber's strings point to buffers that are reused for other requests,
+		// so all strings that go into the span must be copies
+		method := utils.CopyString(c.Method())
+		path := c.Path()
+		if redact {
+			path = redactURL(path)
+		}
+		path = utils.CopyString(path)
+		ctx, span := tracer.Start(ctx, method,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(method),
+				semconv.URLPath(path),
+			),
+		)
+		defer span.End()
+		c.SetUserContext(ctx)
+
+		err := c.Next()
+
+		// After c.Next() the route is the last one that handled the request.
+		// Global middlewares are registered for "/" (see endpointFromRoute), which isn't useful as span name.
+		route := c.Route()
+		if route.Path != "/" || route.Method != "USE" {
+			span.SetName(method + " " + route.Path)
+			span.SetAttributes(semconv.HTTPRoute(route.Path))
+		}
+		for _, param := range route.Params {
+			switch param {
+			case "type":
+				span.SetAttributes(attribute.String("stremio.type", utils.CopyString(c.Params("type"))))
+			case "id":
+				span.SetAttributes(attribute.String("stremio.id", utils.CopyString(c.Params("id"))))
+			}
+		}
+		if requestID, ok := c.Locals("requestID").(string); ok {
+			span.SetAttributes(attribute.String("stremio.request_id", requestID))
+		}
+		if err != nil {
+			span.RecordError(err)
+			span.SetStatus(codes.Error, err.Error())
+		}
+		status := c.Response().StatusCode()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		if status >= 500 {
+			span.SetStatus(codes.Error, "")
+		}
+
+		return err
+	}
+}
+
+// startSpan starts a child span of the request's server span.
+// When tracing is disabled, there's no server span in the request's user context and the returned span is a no-op.
+// The returned context is derived from the request's user context and only meant for carrying the span.
+func startSpan(c *fiber.Ctx, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	ctx := c.UserContext()
+	tracer := trace.SpanFromContext(ctx).TracerProvider().Tracer(tracerName)
+	return tracer.Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// endSpan ends the span and marks it as failed if err isn't nil.
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
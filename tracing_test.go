@@ -0,0 +1,101 @@
+package stremio
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestTracing(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+
+	var handlerSpanContext trace.SpanContext
+	streamHandler := func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		handlerSpanContext = trace.SpanContextFromContext(ctx)
+		return []StreamItem{{URL: "https://example.com/foo.mp4"}}, nil
+	}
+
+	app := fiber.New()
+	app.Use(createTracingMiddleware(tracerProvider, propagation.TraceContext{}, true))
+	handlers := map[string]handler{"movie": convertStreamHandler(streamHandler)}
+	findHandler := func(t, id string) (handler, bool) {
+		h, ok := handlers[t]
+		return h, ok
+	}
+	app.Get("/:userData/stream/:type/:id.json", createStreamHandler(findHandler, 0, false, false, 0, 0, context.Background(), logging.NewNopLogger(), nil, false))
+
+	req := httptest.NewRequest("GET", "/secret/stream/movie/tt1254207.json", nil)
+	req.Header.Set("traceparent", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	res, err := app.Test(req)
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, res.StatusCode)
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2)
+	handlerSpan, serverSpan := spans[0], spans[1]
+
+	// The server span continues the incoming trace
+	require.Equal(t, "GET /:userData/stream/:type/:id.json", serverSpan.Name)
+	require.Equal(t, trace.SpanKindServer, serverSpan.SpanKind)
+	require.Equal(t, "0af7651916cd43dd8448eb211c80319c", serverSpan.SpanContext.TraceID().String())
+	require.Equal(t, "b7ad6b7169203331", serverSpan.Parent.SpanID().String())
+	for _, attr := range serverSpan.Attributes {
+		require.NotContains(t, attr.Value.Emit(), "secret")
+	}
+
+	// The handler gets the handler span in its context
+	require.Equal(t, "streamHandler", handlerSpan.Name)
+	require.Equal(t, serverSpan.SpanContext.SpanID(), handlerSpan.Parent.SpanID())
+	require.Equal(t, handlerSpan.SpanContext, handlerSpanContext)
+}
+
+// TestTracingBatched checks that span attributes stay intact when spans are exported after their request is done
+// and Fiber reused the request's buffers.
+func TestTracingBatched(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tracerProvider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter))
+
+	streamHandler := func(ctx context.Context, id string, userData interface{}) ([]StreamItem, error) {
+		return []StreamItem{{URL: "https://example.com/foo.mp4"}}, nil
+	}
+	app := fiber.New()
+	app.Use(createTracingMiddleware(tracerProvider, propagation.TraceContext{}, false))
+	findHandler := func(t, id string) (handler, bool) { return convertStreamHandler(streamHandler), true }
+	app.Get("/stream/:type/:id.json", createStreamHandler(findHandler, 0, false, false, 0, 0, context.Background(), logging.NewNopLogger(), nil, false))
+
+	ids := []string{"tt0000001", "tt0000002", "tt0000003", "tt0000004"}
+	types := []string{"movie", "series", "movie", "series"}
+	for i := range ids {
+		res, err := app.Test(httptest.NewRequest("GET", "/stream/"+types[i]+"/"+ids[i]+".json", nil))
+		require.NoError(t, err)
+		require.Equal(t, fiber.StatusOK, res.StatusCode)
+	}
+	require.NoError(t, tracerProvider.ForceFlush(context.Background()))
+
+	spans := exporter.GetSpans()
+	require.Len(t, spans, 2*len(ids))
+	for i, span := range spans {
+		// Handler span and server span per request
+		request := i / 2
+		attrs := map[string]string{}
+		for _, attr := range span.Attributes {
+			attrs[string(attr.Key)] = attr.Value.Emit()
+		}
+		require.Equal(t, ids[request], attrs["stremio.id"], span.Name)
+		require.Equal(t, types[request], attrs["stremio.type"], span.Name)
+		if span.SpanKind == trace.SpanKindServer {
+			require.Equal(t, "GET", attrs["http.request.method"])
+			require.Equal(t, "/stream/"+types[request]+"/"+ids[request]+".json", attrs["url.path"])
+		}
+	}
+}
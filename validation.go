@@ -0,0 +1,123 @@
+package stremio
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+// requestRules are the types, ID prefixes and catalog extras that the manifest declares,
+// prepared for validating incoming requests.
+type requestRules struct {
+	// By resource
+	types map[string]map[string]bool
+	// By resource. Empty if the manifest doesn't restrict the IDs.
+	idPrefixes map[string][]string
+	// By type and catalog ID
+	catalogExtras map[string]map[string][]ExtraItem
+}
+
+func newRequestRules(manifest Manifest) requestRules {
+	rules := requestRules{
+		types:         map[string]map[string]bool{},
+		idPrefixes:    map[string][]string{},
+		catalogExtras: map[string]map[string][]ExtraItem{},
+	}
+	for _, item := range manifest.ResourceItems {
+		// Like Stremio, fall back to the manifest's types and ID prefixes
+		types := item.Types
+		if len(types) == 0 {
+			types = manifest.Types
+		}
+		rules.types[item.Name] = map[string]bool{}
+		for _, t := range types {
+			rules.types[item.Name][t] = true
+		}
+		rules.idPrefixes[item.Name] = item.IDprefixes
+		if len(item.IDprefixes) == 0 {
+			rules.idPrefixes[item.Name] = manifest.IDprefixes
+		}
+	}
+	for _, catalog := range manifest.Catalogs {
+		if rules.catalogExtras[catalog.Type] == nil {
+			rules.catalogExtras[catalog.Type] = map[string][]ExtraItem{}
+		}
+		extras := catalog.Extra
+		if extras == nil {
+			extras = []ExtraItem{}
+		}
+		rules.catalogExtras[catalog.Type][catalog.ID] = extras
+	}
+	return rules
+}
+
+// check returns an error if the request doesn't match what the manifest declares.
+// The ID must already be unescaped.
+func (rules requestRules) check(resource, t, id string, extra map[string]string) error {
+	if !rules.types[resource][t] {
+		return fmt.Errorf("Type %q isn't declared for the %v resource", t, resource)
+	}
+
+	if resource == "catalog" {
+		extraItems, ok := rules.catalogExtras[t][id]
+		if !ok {
+			return fmt.Errorf("Catalog %q isn't declared for type %q", id, t)
+		}
+		declared := make(map[string]ExtraItem, len(extraItems))
+		for _, extraItem := range extraItems {
+			declared[extraItem.Name] = extraItem
+			if _, ok := extra[extraItem.Name]; extraItem.IsRequired && !ok {
+				return fmt.Errorf("Required extra %q is missing", extraItem.Name)
+			}
+		}
+		for name, value := range extra {
+			extraItem, ok := declared[name]
+			if !ok {
+				return fmt.Errorf("Extra %q isn't declared for the catalog", name)
+			} else if len(extraItem.Options) > 0 && !containsString(extraItem.Options, value) {
+				return fmt.Errorf("Value %q isn't a declared option of extra %q", value, name)
+			}
+		}
+		return nil
+	}
+
+	if len(rules.idPrefixes[resource]) == 0 {
+		return nil
+	}
+	for _, idPrefix := range rules.idPrefixes[resource] {
+		if strings.HasPrefix(id, idPrefix) {
+			return nil
+		}
+	}
+	return fmt.Errorf("ID %q doesn't have any of the declared prefixes", id)
+}
+
+// createRequestValidationMiddleware creates a middleware that rejects requests with 400 if their type, ID or catalog extra
+// don't match what the manifest declares for the resource.
+// The rules are taken from the current state for each request, so that they follow manifest changes at runtime.
+func createRequestValidationMiddleware(resource string, getState func() *addonState, logger logging.Logger) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		logger := withRequestID(c, logger)
+		id, err := url.PathUnescape(c.Params("id"))
+		if err != nil {
+			logger.Warn("Couldn't unescape ID", "error", err, "id", c.Params("id"))
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		var extra map[string]string
+		if extraString := c.Params("extra"); extraString != "" {
+			if extra, err = parseCatalogExtra(extraString); err != nil {
+				logger.Debug("Rejecting bad request due to unparsable catalog extra", "error", err)
+				return c.SendStatus(fiber.StatusBadRequest)
+			}
+		}
+		if err := getState().requestRules.check(resource, c.Params("type"), id, extra); err != nil {
+			logger.Debug("Rejecting bad request that doesn't match the manifest", "error", err)
+			return c.SendStatus(fiber.StatusBadRequest)
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,83 @@
+package stremio
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/require"
+
+	"github.com/deflix-tv/go-stremio/pkg/logging"
+)
+
+func TestRequestValidation(t *testing.T) {
+	manifest := Manifest{
+		ID:          "foo",
+		Name:        "Foo",
+		Description: "Foo addon",
+		Version:     "0.1.0",
+		ResourceItems: []ResourceItem{
+			{Name: "catalog", Types: []string{"movie"}},
+			{Name: "stream", Types: []string{"movie", "series"}, IDprefixes: []string{"tt", "kitsu:"}},
+		},
+		Types: []string{"movie", "series"},
+		Catalogs: []CatalogItem{
+			{Type: "movie", ID: "top", Name: "Top movies", Extra: []ExtraItem{
+				{Name: "genre", Options: []string{"Action", "Comedy"}},
+				{Name: "skip"},
+			}},
+			{Type: "movie", ID: "search", Name: "Search", Extra: []ExtraItem{
+				{Name: "search", IsRequired: true},
+			}},
+		},
+	}
+	state, err := newAddonState(manifest, handlerSet{})
+	require.NoError(t, err)
+	getState := func() *addonState { return state }
+
+	app := fiber.New()
+	for _, resource := range []string{"catalog", "stream"} {
+		for _, route := range resourceRoutes(resource, false) {
+			app.Use(route, createRequestValidationMiddleware(resource, getState, logging.NewNopLogger()))
+		}
+	}
+	var extra map[string]string
+	catalogHandler := createCatalogHandler(func(t, id string) (handler, bool) {
+		return func(ctx context.Context, id string, userData interface{}) (interface{}, error) {
+			extra = CatalogExtraFromContext(ctx)
+			return []MetaPreviewItem{}, nil
+		}, true
+	}, 0, false, false, 0, 0, context.Background(), logging.NewNopLogger(), nil, false)
+	app.Get("/catalog/:type/:id.json", catalogHandler)
+	app.Get("/catalog/:type/:id/:extra.json", catalogHandler)
+	app.Get("/stream/:type/:id.json", func(c *fiber.Ctx) error {
+		return c.SendStatus(fiber.StatusOK)
+	})
+
+	for path, expected := range map[string]int{
+		"/catalog/movie/top.json":                        fiber.StatusOK,
+		"/catalog/movie/top/genre=Action.json":           fiber.StatusOK,
+		"/catalog/movie/top/genre=Action&skip=100.json":  fiber.StatusOK,
+		"/catalog/movie/top/genre=Horror.json":           fiber.StatusBadRequest,
+		"/catalog/movie/top/foo=bar.json":                fiber.StatusBadRequest,
+		"/catalog/movie/search.json":                     fiber.StatusBadRequest,
+		"/catalog/movie/search/search=the%20matrix.json": fiber.StatusOK,
+		"/catalog/movie/popular.json":                    fiber.StatusBadRequest,
+		"/catalog/series/top.json":                       fiber.StatusBadRequest,
+		"/stream/movie/tt0133093.json":                   fiber.StatusOK,
+		"/stream/series/kitsu%3A1%3A1.json":              fiber.StatusOK,
+		"/stream/series/myaddon:1.json":                  fiber.StatusBadRequest,
+		"/stream/channel/tt0133093.json":                 fiber.StatusBadRequest,
+	} {
+		res, err := app.Test(httptest.NewRequest("GET", path, nil))
+		require.NoError(t, err)
+		require.Equal(t, expected, res.StatusCode, path)
+	}
+
+	// The extra is available to the handler, with URL-decoded values
+	res, err := app.Test(httptest.NewRequest("GET", "/catalog/movie/search/search=the%20matrix.json", nil))
+	require.NoError(t, err)
+	require.Equal(t, fiber.StatusOK, res.StatusCode)
+	require.Equal(t, map[string]string{"search": "the matrix"}, extra)
+}